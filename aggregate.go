@@ -0,0 +1,57 @@
+package plugin
+
+// Aggregation defines how values of metric added multiple times are
+// combined into the reported value
+type Aggregation int
+
+// Supported aggregations
+const (
+	AggregateNone Aggregation = iota
+	AggregateLast
+	AggregateMax
+	AggregateMin
+	AggregateAvg
+	AggregateSum
+)
+
+// String returns aggregation name
+func (a Aggregation) String() string {
+	switch a {
+	case AggregateNone:
+		return "none"
+	case AggregateLast:
+		return "last"
+	}
+	if stat, ok := a.stat(); ok {
+		return stat.String()
+	}
+	return "unknown"
+}
+
+// stat returns aggregate function of the aggregation, false for
+// aggregations keeping the last sample
+func (a Aggregation) stat() (Stat, bool) {
+	switch a {
+	case AggregateMax:
+		return StatMax, true
+	case AggregateMin:
+		return StatMin, true
+	case AggregateAvg:
+		return StatAvg, true
+	case AggregateSum:
+		return StatSum, true
+	default:
+		return 0, false
+	}
+}
+
+// aggregate returns aggregated value of samples, value is the last sample as
+// provided to AddMetric
+func (a Aggregation) aggregate(samples []float64, value interface{}) (interface{}, float64) {
+	stat, ok := a.stat()
+	if !ok {
+		return value, samples[len(samples)-1]
+	}
+	result := stat.compute(samples)
+	return result, result
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestAggregationString(t *testing.T) {
+	tests := []struct {
+		in   Aggregation
+		text string
+	}{
+		{AggregateNone, "none"},
+		{AggregateLast, "last"},
+		{AggregateMax, "max"},
+		{AggregateMin, "min"},
+		{AggregateAvg, "avg"},
+		{AggregateSum, "sum"},
+		{Aggregation(10), "unknown"},
+	}
+
+	for _, test := range tests {
+		out := test.in.String()
+		if test.text != out {
+			t.Errorf("Got %s, expected %s", out, test.text)
+		}
+	}
+}
+
+func TestMetricAggregation(t *testing.T) {
+	tests := []struct {
+		aggregation      Aggregation
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{AggregateLast, OK, "OK: requests is 4 | requests=4;15;;;\n"},
+		{AggregateMax, OK, "OK: requests is 12 | requests=12;15;;;\n"},
+		{AggregateMin, OK, "OK: requests is 2 | requests=2;15;;;\n"},
+		{AggregateAvg, OK, "OK: requests is 6 | requests=6;15;;;\n"},
+		{AggregateSum, WARNING, "WARNING: requests is 18 (outside 15) | requests=18;15;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.AllMetricsInOutput = true
+			check.EmptyValueAsUnknown = true
+			check.MetricAggregation = test.aggregation
+			for _, v := range []interface{}{"", 12, 2, "", 4} {
+				if err := check.AddMetric("requests", v, "", "15"); err != nil {
+					t.Errorf("Got error: '%s', expected none", err)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("%s: Got output: '%s', expected: '%s'", test.aggregation, gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("%s: Got code: %d, expected: %d", test.aggregation, exitHandler.code, test.expectedExitCode)
+		}
+	}
+
+	// status is never lowered, alert message is replaced
+	exitHandler := initExitHandler()
+	func() {
+		check := New("check_plugin", "v1.0")
+		defer check.Final()
+		check.MetricAggregation = AggregateAvg
+		check.AddMessage("Service ok")
+		check.AddMetric("rta", 30, "ms", "20")
+		check.AddMessage("Still ok")
+		check.AddMetric("rta", 10, "ms", "20")
+		check.AddMetric("pl", 5, "%", "1")
+	}()
+	expected := "WARNING: Service ok, Still ok, pl is 5% (outside 1) | pl=5%;1;;0;100 rta=20ms;20;;;\n"
+	if exitHandler.output.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+	}
+}
@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+/*
+CheckCertExpiry adds result for certificate expiring at notAfter: CRITICAL
+if already expired or expiring within crit, WARNING if expiring within warn,
+OK otherwise. Both limits are inclusive - certificate expiring in exactly
+crit is CRITICAL. Whole days until expiry (negative once expired, so a
+certificate expired an hour ago is reported as expired 1 day ago) are added
+as days_until_expiry metric - if it cannot be added (e.g. it was already
+added by previous call) the error is reported in debug output. The
+resulting status is returned.
+
+	cert := conn.ConnectionState().PeerCertificates[0]
+	check.CheckCertExpiry(cert.NotAfter, 30*24*time.Hour, 7*24*time.Hour)
+
+*/
+func (p *Plugin) CheckCertExpiry(notAfter time.Time, warn, crit time.Duration) Status {
+	left := notAfter.Sub(pNow())
+	days := int(math.Floor(left.Hours() / 24))
+
+	var status Status
+	switch {
+	case left <= 0:
+		status = CRITICAL
+	case left <= crit:
+		status = CRITICAL
+	case left <= warn:
+		status = WARNING
+	default:
+		status = OK
+	}
+
+	expires := notAfter.UTC().Format("2006-01-02 15:04:05 MST")
+	if left <= 0 {
+		p.AddResult(status, "Certificate expired %s ago (%s)", formatDays(-days), expires)
+	} else {
+		p.AddResult(status, "Certificate expires in %s (%s)", formatDays(days), expires)
+	}
+	if err := p.AddMetric("days_until_expiry", days); err != nil {
+		p.debugf("Cannot add certificate expiry metric: %s", err)
+	}
+	return status
+}
+
+// formatDays returns number of days with the unit, e.g. "1 day", "3 days"
+func formatDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return strconv.Itoa(days) + " days"
+}
@@ -0,0 +1,69 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	pNow = func() time.Time { return now }
+	defer func() { pNow = time.Now }()
+
+	day := 24 * time.Hour
+	warn, crit := 30*day, 7*day
+
+	tests := []struct {
+		notAfter       time.Time
+		expectedStatus Status
+		expectedOutput string
+	}{
+		{now.Add(60 * day), OK, "OK: Certificate expires in 60 days (2020-03-10 12:00:00 UTC) | days_until_expiry=60;;;;\n"},
+		{now.Add(warn + time.Second), OK, "OK: Certificate expires in 30 days (2020-02-09 12:00:01 UTC) | days_until_expiry=30;;;;\n"},
+		{now.Add(warn), WARNING, "WARNING: Certificate expires in 30 days (2020-02-09 12:00:00 UTC) | days_until_expiry=30;;;;\n"},
+		{now.Add(crit + time.Second), WARNING, "WARNING: Certificate expires in 7 days (2020-01-17 12:00:01 UTC) | days_until_expiry=7;;;;\n"},
+		{now.Add(crit), CRITICAL, "CRITICAL: Certificate expires in 7 days (2020-01-17 12:00:00 UTC) | days_until_expiry=7;;;;\n"},
+		{now.Add(30 * time.Hour), CRITICAL, "CRITICAL: Certificate expires in 1 day (2020-01-11 18:00:00 UTC) | days_until_expiry=1;;;;\n"},
+		{now.Add(time.Second), CRITICAL, "CRITICAL: Certificate expires in 0 days (2020-01-10 12:00:01 UTC) | days_until_expiry=0;;;;\n"},
+		{now, CRITICAL, "CRITICAL: Certificate expired 0 days ago (2020-01-10 12:00:00 UTC) | days_until_expiry=0;;;;\n"},
+		{now.Add(-time.Hour), CRITICAL, "CRITICAL: Certificate expired 1 day ago (2020-01-10 11:00:00 UTC) | days_until_expiry=-1;;;;\n"},
+		{now.Add(-3 * day), CRITICAL, "CRITICAL: Certificate expired 3 days ago (2020-01-07 12:00:00 UTC) | days_until_expiry=-3;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+
+		status := check.CheckCertExpiry(test.notAfter, warn, crit)
+		if status != test.expectedStatus {
+			t.Errorf("Got status: %s, expected: %s for %s", status, test.expectedStatus, test.notAfter)
+		}
+
+		check.Final()
+		if exitHandler.code != test.expectedStatus {
+			t.Errorf("Got code: %d, expected: %d for %s", exitHandler.code, test.expectedStatus, test.notAfter)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestCheckCertExpiryTwice(t *testing.T) {
+	now := time.Date(2020, 1, 10, 12, 0, 0, 0, time.UTC)
+	pNow = func() time.Time { return now }
+	defer func() { pNow = time.Now }()
+
+	initExitHandler()
+	var debug bytes.Buffer
+	check := New("check_plugin", "v1.0")
+	check.DebugOutput = &debug
+	check.CheckCertExpiry(now.Add(60*24*time.Hour), time.Hour, time.Hour)
+	check.CheckCertExpiry(now.Add(-time.Hour), time.Hour, time.Hour)
+
+	expected := "Cannot add certificate expiry metric: Duplicated metric days_until_expiry\n"
+	if debug.String() != expected {
+		t.Errorf("Got debug output: '%s', expected: '%s'", debug.String(), expected)
+	}
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type subCheckResult struct {
+	status  Status
+	message string
+}
+
+/*
+RunChecks runs named sub-checks and adds their results, with messages
+prefixed by the sub-check name, in order of names. A sub-check which panics
+results in UNKNOWN status. If MaxConcurrentChecks is greater than 1, up to
+that many sub-checks are run concurrently.
+
+	check.MaxConcurrentChecks = 4
+	check.RunChecks(map[string]func() (plugin.Status, string){
+		"disk": checkDisk,
+		"load": checkLoad,
+	})
+
+*/
+func (p *Plugin) RunChecks(checks map[string]func() (Status, string)) {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]subCheckResult, len(names))
+
+	workers := p.MaxConcurrentChecks
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, fn func() (Status, string)) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runSubCheck(fn)
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		p.AddResult(results[i].status, "%s: %s", name, results[i].message)
+	}
+}
+
+func runSubCheck(fn func() (Status, string)) (result subCheckResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = subCheckResult{UNKNOWN, fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+	result.status, result.message = fn()
+	return
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunChecks(t *testing.T) {
+	tests := []struct {
+		concurrency      int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{0, UNKNOWN, "UNKNOWN: disk: 45% used, load: load average 5.1, memory: panic: out of memory, ping: alive\n"},
+		{3, UNKNOWN, "UNKNOWN: disk: 45% used, load: load average 5.1, memory: panic: out of memory, ping: alive\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		var running, maxRunning int32
+
+		track := func(status Status, message string) func() (Status, string) {
+			return func() (Status, string) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return status, message
+			}
+		}
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.MaxConcurrentChecks = test.concurrency
+			check.RunChecks(map[string]func() (Status, string){
+				"ping":   track(OK, "alive"),
+				"load":   track(WARNING, "load average 5.1"),
+				"disk":   track(OK, "45% used"),
+				"memory": func() (Status, string) { panic("out of memory") },
+			})
+		}()
+
+		if test.concurrency < 2 && maxRunning != 1 {
+			t.Errorf("Got %d sub-checks running concurrently, expected 1", maxRunning)
+		}
+		if maxRunning > int32(test.concurrency) && test.concurrency > 1 {
+			t.Errorf("Got %d sub-checks running concurrently, expected at most %d", maxRunning, test.concurrency)
+		}
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
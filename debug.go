@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const redactedValue = "*****"
+
+/*
+RecordInvocation captures the command line the check was started with and,
+if DebugOutput is set, writes it there. Values of flags listed in
+SensitiveFlags (long names, without leading dashes) are redacted.
+
+	check.DebugOutput = os.Stderr
+	check.SensitiveFlags = []string{"password"}
+	check.RecordInvocation()
+
+*/
+func (p *Plugin) RecordInvocation() {
+	args := make([]string, 0, len(pArgs)+1)
+	args = append(args, os.Args[0])
+
+	sensitive := make(map[string]bool, len(p.SensitiveFlags))
+	for _, f := range p.SensitiveFlags {
+		sensitive[strings.TrimLeft(f, "-")] = true
+	}
+
+	var redactNext bool
+	for _, a := range pArgs {
+		switch {
+		case redactNext:
+			a = redactedValue
+			redactNext = false
+		case strings.HasPrefix(a, "--"):
+			name := strings.TrimPrefix(a, "--")
+			if i := strings.IndexRune(name, '='); i >= 0 {
+				if sensitive[name[:i]] {
+					a = "--" + name[:i] + "=" + redactedValue
+				}
+			} else if sensitive[name] {
+				redactNext = true
+			}
+		}
+		if strings.ContainsAny(a, " \t") {
+			a = "'" + a + "'"
+		}
+		args = append(args, a)
+	}
+
+	p.debugf("Invocation: %s", strings.Join(args, " "))
+}
+
+/*
+SetRequestID sets correlation ID of the check run, which is included in
+debug output and structured exports (but not in the check output line). If
+id is empty a random UUID is generated.
+
+	check.SetRequestID(os.Getenv("REQUEST_ID"))
+
+*/
+func (p *Plugin) SetRequestID(id string) {
+	if len(id) == 0 {
+		id = newUUID()
+	}
+	p.reqID = id
+}
+
+// RequestID returns correlation ID of the check run, empty if not set
+func (p *Plugin) RequestID() string {
+	return p.reqID
+}
+
+// newUUID returns random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (p *Plugin) debugf(format string, args ...interface{}) {
+	if p.DebugOutput == nil {
+		return
+	}
+	if len(p.reqID) > 0 {
+		format = "[" + p.reqID + "] " + format
+	}
+	if p.CombinedStreamSafe {
+		fmt.Fprintf(p.debugBuf, format+"\n", args...)
+		return
+	}
+	fmt.Fprintf(p.DebugOutput, format+"\n", args...)
+}
+
+// flushDebug writes buffered debug output if the status is not OK
+func (p *Plugin) flushDebug() {
+	if p.DebugOutput != nil && p.Status() != OK {
+		p.debugBuf.WriteTo(p.DebugOutput)
+	}
+	p.debugBuf.Reset()
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"testing"
+)
+
+type RecordInvocationTest struct {
+	args           []string
+	sensitive      []string
+	expectedOutput string
+}
+
+func TestRecordInvocation(t *testing.T) {
+	prog := os.Args[0]
+	tests := []RecordInvocationTest{
+		{
+			[]string{"-H", "localhost"},
+			nil,
+			"Invocation: " + prog + " -H localhost\n",
+		},
+		{
+			[]string{"-H", "localhost", "--password", "secret", "-m", "two words"},
+			[]string{"password"},
+			"Invocation: " + prog + " -H localhost --password ***** -m 'two words'\n",
+		},
+		{
+			[]string{"--password=secret", "--token", "abc"},
+			[]string{"--password", "token"},
+			"Invocation: " + prog + " --password=***** --token *****\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler(test.args)
+
+		var debug bytes.Buffer
+		check := New("check_plugin", "v1.0")
+		check.DebugOutput = &debug
+		check.SensitiveFlags = test.sensitive
+		check.RecordInvocation()
+
+		if debug.String() != test.expectedOutput {
+			t.Errorf("Got debug output: '%s', expected: '%s'", debug.String(), test.expectedOutput)
+		}
+		if exitHandler.output.Len() != 0 {
+			t.Errorf("Got output: '%s', expected none", exitHandler.output.String())
+		}
+	}
+
+	// no debug writer - nothing happens
+	initExitHandler([]string{"-H", "localhost"})
+	New("check_plugin", "v1.0").RecordInvocation()
+}
+
+func TestCombinedStreamSafe(t *testing.T) {
+	prog := os.Args[0]
+	tests := []struct {
+		combined       bool
+		status         Status
+		expectedOutput string
+	}{
+		{false, OK, "Invocation: " + prog + " -H localhost\nOK: All ok\n"},
+		{true, OK, "OK: All ok\n"},
+		{true, CRITICAL, "CRITICAL: All ok\nInvocation: " + prog + " -H localhost\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler([]string{"-H", "localhost"})
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.DebugOutput = exitHandler
+			check.CombinedStreamSafe = test.combined
+			check.RecordInvocation()
+			check.AddResult(test.status, "All ok")
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+	}
+}
+
+func TestSetRequestID(t *testing.T) {
+	initExitHandler([]string{"-H", "localhost"})
+
+	var debug bytes.Buffer
+	check := New("check_plugin", "v1.0")
+	check.DebugOutput = &debug
+	check.SetRequestID("abc-123")
+	check.RecordInvocation()
+
+	expected := "[abc-123] Invocation: " + os.Args[0] + " -H localhost\n"
+	if debug.String() != expected {
+		t.Errorf("Got debug output: '%s', expected: '%s'", debug.String(), expected)
+	}
+
+	uuidRe := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	check.SetRequestID("")
+	first := check.RequestID()
+	if !uuidRe.MatchString(first) {
+		t.Errorf("Got request ID: '%s', expected UUID", first)
+	}
+	check.SetRequestID("")
+	if check.RequestID() == first {
+		t.Errorf("Got the same generated request ID twice: '%s'", first)
+	}
+}
@@ -0,0 +1,229 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Metric is an exported metric with its thresholds and status. Value is
+// json.Number, preserving integer (e.g. 123) and float (e.g. 123.0) values,
+// or "U" for undetermined metrics.
+type Metric struct {
+	Name     string           `json:"name"`
+	Value    interface{}      `json:"value"`
+	UOM      string           `json:"uom,omitempty"`
+	Status   Status           `json:"status"`
+	Warning  *MetricThreshold `json:"warning,omitempty"`
+	Critical *MetricThreshold `json:"critical,omitempty"`
+	Info     bool             `json:"info,omitempty"`
+}
+
+// jsonResult is the check result as written by WriteJSON, fields are
+// encoded in the order of declaration
+type jsonResult struct {
+	Name          string                              `json:"name"`
+	Status        string                              `json:"status"`
+	ExitCode      int                                 `json:"exit_code"`
+	Message       string                              `json:"message"`
+	Messages      []string                            `json:"messages"`
+	Metrics       []Metric                            `json:"metrics"`
+	Arrays        map[string][]map[string]interface{} `json:"arrays,omitempty"`
+	Documentation []string                            `json:"documentation,omitempty"`
+	Hostname      string                              `json:"hostname,omitempty"`
+	RequestID     string                              `json:"request_id,omitempty"`
+}
+
+// MetricThreshold is an exported threshold. Min and Max are nil for
+// unbounded ends of the range. Thresholds which cannot be parsed are
+// exported as raw strings only.
+type MetricThreshold struct {
+	Raw    string
+	Min    *float64
+	Max    *float64
+	Invert bool
+	Valid  bool
+}
+
+// MarshalJSON encodes threshold as {"min", "max", "invert"} object, or raw
+// string if it is not valid
+func (t *MetricThreshold) MarshalJSON() ([]byte, error) {
+	if !t.Valid {
+		return json.Marshal(t.Raw)
+	}
+	return json.Marshal(struct {
+		Min    *float64 `json:"min"`
+		Max    *float64 `json:"max"`
+		Invert bool     `json:"invert"`
+	}{t.Min, t.Max, t.Invert})
+}
+
+func newMetricThreshold(raw string) *MetricThreshold {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	t := &MetricThreshold{Raw: raw}
+	r, err := parseThreshold(raw)
+	if err != nil {
+		return t
+	}
+
+	t.Valid = true
+	t.Invert = r.invert
+	if r.hasStart {
+		start := r.start
+		t.Min = &start
+	}
+	if r.hasEnd {
+		end := r.end
+		t.Max = &end
+	}
+	return t
+}
+
+// exportValue returns metric value as json.Number, keeping integer and float
+// values distinct
+func (m *checkMetric) exportValue() interface{} {
+	val, err := i2f(m.value)
+	switch {
+	case err != nil:
+		return m.value
+	case math.IsNaN(val) || math.IsInf(val, 0):
+		return fmt.Sprint(val)
+	case m.integer:
+		if s, ok := m.value.(string); ok {
+			// normalize e.g. "+5" or "007", which are not valid JSON numbers
+			n, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			return json.Number(strconv.FormatInt(n, 10))
+		}
+		return json.Number(fmt.Sprint(m.value))
+	}
+
+	s := strconv.FormatFloat(val, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return json.Number(s)
+}
+
+/*
+SortedMetrics returns all metrics, sorted by name, with their status and
+thresholds parsed into structured form.
+
+	b, _ := json.Marshal(check.SortedMetrics())
+
+*/
+func (p *Plugin) SortedMetrics() []Metric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sortedMetrics()
+}
+
+// sortedMetrics returns metrics as SortedMetrics, p.mu must be held
+func (p *Plugin) sortedMetrics() []Metric {
+	names := p.sortedMetricNames()
+	metrics := make([]Metric, 0, len(names))
+	for _, name := range names {
+		m := p.metrics[name]
+		metrics = append(metrics, Metric{
+			Name:     name,
+			Value:    m.exportValue(),
+			UOM:      m.uom,
+			Status:   m.status,
+			Warning:  newMetricThreshold(m.warn),
+			Critical: newMetricThreshold(m.critical),
+			Info:     m.info,
+		})
+	}
+	return metrics
+}
+
+// groupMetricArrays splits metrics into flat ones and arrays of metrics with
+// names matching JSONArrayPattern. Array elements are sorted by index, each
+// has "index" key and a key for each of its fields.
+func (p *Plugin) groupMetricArrays(metrics []Metric) ([]Metric, map[string][]map[string]interface{}) {
+	if p.JSONArrayPattern == nil {
+		return metrics, nil
+	}
+
+	flat := make([]Metric, 0, len(metrics))
+	elements := make(map[string]map[int]map[string]interface{})
+	for _, m := range metrics {
+		match := p.JSONArrayPattern.FindStringSubmatch(m.Name)
+		if len(match) < 4 || match[3] == "index" {
+			flat = append(flat, m)
+			continue
+		}
+		index, err := strconv.Atoi(match[2])
+		if err != nil {
+			flat = append(flat, m)
+			continue
+		}
+
+		name := match[1]
+		if elements[name] == nil {
+			elements[name] = make(map[int]map[string]interface{})
+		}
+		element := elements[name][index]
+		if element == nil {
+			element = map[string]interface{}{"index": index}
+			elements[name][index] = element
+		}
+		element[match[3]] = m
+	}
+	if len(elements) == 0 {
+		return flat, nil
+	}
+
+	arrays := make(map[string][]map[string]interface{}, len(elements))
+	for name, byIndex := range elements {
+		indexes := make([]int, 0, len(byIndex))
+		for index := range byIndex {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			arrays[name] = append(arrays[name], byIndex[index])
+		}
+	}
+	return flat, arrays
+}
+
+/*
+WriteJSON writes the check result - status, messages (both joined and as
+list), metrics and documentation URLs (see AddResultDoc) - as JSON.
+Metrics are sorted by name and object keys are always in the same order, so
+the output is byte-identical for identical check state.
+Metrics with names matching JSONArrayPattern are written under "arrays"
+instead of "metrics", e.g. "disk_0_usage" and "disk_1_usage" as
+{"disk": [{"index": 0, "usage": {...}}, {"index": 1, "usage": {...}}]}.
+
+	check.WriteJSON(os.Stdout)
+
+*/
+func (p *Plugin) WriteJSON(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	messages := make([]string, len(p.messages))
+	copy(messages, p.messages)
+	metrics, arrays := p.groupMetricArrays(p.sortedMetrics())
+
+	return json.NewEncoder(w).Encode(jsonResult{
+		Name:          p.Name,
+		Status:        p.status.String(),
+		ExitCode:      p.status.ExitCode(),
+		Message:       strings.Join(p.messages, p.MessageSeparator),
+		Messages:      messages,
+		Metrics:       metrics,
+		Arrays:        arrays,
+		Documentation: p.documentation,
+		Hostname:      p.hostname(),
+		RequestID:     p.reqID,
+	})
+}
@@ -0,0 +1,192 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"regexp"
+	"testing"
+)
+
+func TestSortedMetrics(t *testing.T) {
+	initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.EmptyValueAsUnknown = true
+	check.AddMetric("simple", 5, "", "10", "20")
+	check.AddMetric("range", 15, "ms", "10:20", "~:30")
+	check.AddMetric("inverted", 25, "", "@20:30", "30:")
+	check.AddMetric("no data", "", "", "10")
+	check.AddMetric("plain", 1)
+
+	b, err := json.Marshal(check.SortedMetrics())
+	if err != nil {
+		t.Fatalf("Got error: '%s', expected none", err)
+	}
+
+	expected := `[` +
+		`{"name":"inverted","value":25,"status":2,"warning":{"min":20,"max":30,"invert":true},"critical":{"min":30,"max":null,"invert":false}},` +
+		`{"name":"no data","value":"U","status":0,"warning":{"min":0,"max":10,"invert":false}},` +
+		`{"name":"plain","value":1,"status":0},` +
+		`{"name":"range","value":15,"uom":"ms","status":0,"warning":{"min":10,"max":20,"invert":false},"critical":{"min":null,"max":30,"invert":false}},` +
+		`{"name":"simple","value":5,"status":0,"warning":{"min":0,"max":10,"invert":false},"critical":{"min":0,"max":20,"invert":false}}` +
+		`]`
+	if string(b) != expected {
+		t.Errorf("Got: '%s', expected: '%s'", b, expected)
+	}
+}
+
+func TestSortedMetricsValueTypes(t *testing.T) {
+	initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.AddMetric("int", 123)
+	check.AddMetric("uint8", uint8(7))
+	check.AddMetric("int string", "42")
+	check.AddMetric("signed string", "+5")
+	check.AddMetric("padded string", "007")
+	check.AddMetric("float", 123.0)
+	check.AddMetric("float32", float32(0.5))
+	check.AddMetric("float string", "1.50")
+	check.AddMetric("big", 1e21)
+	check.AddMetric("nan", math.NaN())
+
+	b, err := json.Marshal(check.SortedMetrics())
+	if err != nil {
+		t.Fatalf("Got error: '%s', expected none", err)
+	}
+
+	expected := `[` +
+		`{"name":"big","value":1e+21,"status":0},` +
+		`{"name":"float","value":123.0,"status":0},` +
+		`{"name":"float string","value":1.5,"status":0},` +
+		`{"name":"float32","value":0.5,"status":0},` +
+		`{"name":"int","value":123,"status":0},` +
+		`{"name":"int string","value":42,"status":0},` +
+		`{"name":"nan","value":"NaN","status":0},` +
+		`{"name":"padded string","value":7,"status":0},` +
+		`{"name":"signed string","value":5,"status":0},` +
+		`{"name":"uint8","value":7,"status":0}` +
+		`]`
+	if string(b) != expected {
+		t.Errorf("Got: '%s', expected: '%s'", b, expected)
+	}
+
+	// integer-ness survives aggregation, except for averages
+	for _, test := range []struct {
+		aggregation Aggregation
+		expected    string
+	}{
+		{AggregateSum, `[{"name":"requests","value":6,"status":0}]`},
+		{AggregateAvg, `[{"name":"requests","value":3.0,"status":0}]`},
+	} {
+		check := New("check_plugin", "v1.0")
+		check.MetricAggregation = test.aggregation
+		check.AddMetric("requests", 2)
+		check.AddMetric("requests", 4)
+		b, _ := json.Marshal(check.SortedMetrics())
+		if string(b) != test.expected {
+			t.Errorf("%s: Got: '%s', expected: '%s'", test.aggregation, b, test.expected)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	initExitHandler()
+
+	render := func() string {
+		check := New("check_plugin", "v1.0")
+		check.SetRequestID("abc-123")
+		check.AddMessage("Service localhost:80")
+		for _, name := range []string{"m3", "m1", "m5", "m2", "m4"} {
+			check.AddMetric(name, 1.5, "s", "1")
+		}
+		var b bytes.Buffer
+		if err := check.WriteJSON(&b); err != nil {
+			t.Fatalf("Got error: '%s', expected none", err)
+		}
+		return b.String()
+	}
+
+	first := render()
+	for i := 0; i < 10; i++ {
+		if out := render(); out != first {
+			t.Fatalf("Got different output: '%s', expected: '%s'", out, first)
+		}
+	}
+
+	metric := func(name string) string {
+		return `{"name":"` + name + `","value":1.5,"uom":"s","status":1,"warning":{"min":0,"max":1,"invert":false}}`
+	}
+	expected := `{"name":"check_plugin","status":"WARNING","exit_code":1,` +
+		`"message":"Service localhost:80, m3 is 1.5s (outside 1), m1 is 1.5s (outside 1), m5 is 1.5s (outside 1), m2 is 1.5s (outside 1), m4 is 1.5s (outside 1)",` +
+		`"messages":["Service localhost:80","m3 is 1.5s (outside 1)","m1 is 1.5s (outside 1)","m5 is 1.5s (outside 1)","m2 is 1.5s (outside 1)","m4 is 1.5s (outside 1)"],` +
+		`"metrics":[` + metric("m1") + "," + metric("m2") + "," + metric("m3") + "," + metric("m4") + "," + metric("m5") + `],` +
+		`"request_id":"abc-123"}` + "\n"
+	if first != expected {
+		t.Errorf("Got: '%s', expected: '%s'", first, expected)
+	}
+}
+
+func TestJSONArrayPattern(t *testing.T) {
+	initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.JSONArrayPattern = regexp.MustCompile(`^(\w+?)_(\d+)_(\w+)$`)
+	check.AddMetric("disk_10_usage", 40, "%")
+	check.AddMetric("disk_2_usage", 95, "%", "80", "90")
+	check.AddMetric("disk_2_inodes", 10, "%")
+	check.AddMetric("disk_x_usage", 5, "%")
+	check.AddMetric("load", 1)
+	check.AddMetric("nic_0_errors", 3, "c")
+
+	var b bytes.Buffer
+	if err := check.WriteJSON(&b); err != nil {
+		t.Fatalf("Got error: '%s', expected none", err)
+	}
+
+	expected := `{"name":"check_plugin","status":"CRITICAL","exit_code":2,"message":"disk_2_usage is 95% (outside 90)",` +
+		`"messages":["disk_2_usage is 95% (outside 90)"],` +
+		`"metrics":[{"name":"disk_x_usage","value":5,"uom":"%","status":0},{"name":"load","value":1,"status":0}],` +
+		`"arrays":{"disk":[` +
+		`{"index":2,"inodes":{"name":"disk_2_inodes","value":10,"uom":"%","status":0},` +
+		`"usage":{"name":"disk_2_usage","value":95,"uom":"%","status":2,"warning":{"min":0,"max":80,"invert":false},"critical":{"min":0,"max":90,"invert":false}}},` +
+		`{"index":10,"usage":{"name":"disk_10_usage","value":40,"uom":"%","status":0}}],` +
+		`"nic":[{"errors":{"name":"nic_0_errors","value":3,"uom":"c","status":0},"index":0}]}}` + "\n"
+	if b.String() != expected {
+		t.Errorf("Got: '%s', expected: '%s'", b.String(), expected)
+	}
+}
+
+func TestOutputFormatJSON(t *testing.T) {
+	tests := []struct {
+		format           Format
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{"", WARNING, "WARNING: Degraded, rta is 24.558ms (outside 20) | rta=24.558ms;20;50;;\n"},
+		{FormatNagios, WARNING, "WARNING: Degraded, rta is 24.558ms (outside 20) | rta=24.558ms;20;50;;\n"},
+		{
+			FormatJSON, WARNING,
+			`{"name":"check_plugin","status":"WARNING","exit_code":1,"message":"Degraded, rta is 24.558ms (outside 20)",` +
+				`"messages":["Degraded","rta is 24.558ms (outside 20)"],` +
+				`"metrics":[{"name":"rta","value":24.558,"uom":"ms","status":1,"warning":{"min":0,"max":20,"invert":false},"critical":{"min":0,"max":50,"invert":false}}]}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.OutputFormat = test.format
+		check.AddMessage("Degraded")
+		check.AddMetric("rta", 24.558, "ms", "20", "50")
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
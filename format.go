@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"io"
+	"sync"
+)
+
+// Format is the check output format, name of registered formatter, see
+// OutputFormat and RegisterFormatter
+type Format string
+
+// Built-in output formats
+const (
+	// Nagios plugin output - "STATUS: messages | perfdata"
+	FormatNagios Format = "nagios"
+	// JSON object, as written by WriteJSON
+	FormatJSON Format = "json"
+	// Prometheus text exposition format
+	FormatPrometheus Format = "prometheus"
+	// OpenMetrics text format, as written by WriteOpenMetrics
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+var (
+	formattersMu sync.RWMutex
+	formatters   = map[Format]func(*Plugin, io.Writer) error{
+		FormatNagios: func(p *Plugin, w io.Writer) error {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.render(w)
+			return nil
+		},
+		FormatJSON:        (*Plugin).WriteJSON,
+		FormatPrometheus:  (*Plugin).WritePrometheus,
+		FormatOpenMetrics: (*Plugin).WriteOpenMetrics,
+	}
+)
+
+/*
+RegisterFormatter registers output formatter, which can be selected by its
+name with OutputFormat. Registering formatter with the name of existing one
+replaces it. Formatters are called without any lock of the check held, so
+they can use its methods, e.g. SortedMetrics or GetMetric.
+
+	plugin.RegisterFormatter("influx", func(check *plugin.Plugin, w io.Writer) error {
+		for _, m := range check.SortedMetrics() {
+			fmt.Fprintf(w, "%s value=%s\n", m.Name, m.Value)
+		}
+		return nil
+	})
+	check.OutputFormat = "influx"
+
+*/
+func RegisterFormatter(name string, fn func(*Plugin, io.Writer) error) {
+	formattersMu.Lock()
+	defer formattersMu.Unlock()
+	formatters[Format(name)] = fn
+}
+
+// lookupFormatter returns formatter registered for format, Nagios formatter
+// if format is empty or unknown - ok is false for unknown formats
+func lookupFormatter(format Format) (fn func(*Plugin, io.Writer) error, ok bool) {
+	formattersMu.RLock()
+	defer formattersMu.RUnlock()
+	if len(format) == 0 {
+		return formatters[FormatNagios], true
+	}
+	if fn, ok := formatters[format]; ok {
+		return fn, true
+	}
+	return formatters[FormatNagios], false
+}
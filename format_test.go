@@ -0,0 +1,100 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRegisterFormatter(t *testing.T) {
+	defer func() {
+		formattersMu.Lock()
+		delete(formatters, "test")
+		formattersMu.Unlock()
+	}()
+
+	RegisterFormatter("test", func(check *Plugin, w io.Writer) error {
+		for _, m := range check.SortedMetrics() {
+			fmt.Fprintf(w, "%s %s value=%s\n", check.Name, m.Name, m.Value)
+		}
+		return nil
+	})
+
+	tests := []struct {
+		format         Format
+		expectedOutput string
+		expectedDebug  string
+	}{
+		{"", "WARNING: rta is 24.558ms (outside 20) | pl=0%;;;0;100 rta=24.558ms;20;50;;\n", ""},
+		{"nagios", "WARNING: rta is 24.558ms (outside 20) | pl=0%;;;0;100 rta=24.558ms;20;50;;\n", ""},
+		{
+			"unknown", "WARNING: rta is 24.558ms (outside 20) | pl=0%;;;0;100 rta=24.558ms;20;50;;\n",
+			"Unknown output format unknown, using nagios\n",
+		},
+		{"test", "check_plugin pl value=0\ncheck_plugin rta value=24.558\n", ""},
+		{
+			"prometheus", "# TYPE check_plugin_status gauge\ncheck_plugin_status 1\n# TYPE pl gauge\npl{uom=\"%\"} 0\n" +
+				"# TYPE rta gauge\nrta{uom=\"ms\"} 24.558\n# TYPE rta_warn gauge\nrta_warn{uom=\"ms\"} 20\n" +
+				"# TYPE rta_crit gauge\nrta_crit{uom=\"ms\"} 50\n",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		var debug bytes.Buffer
+		check := New("check_plugin", "v1.0")
+		check.DebugOutput = &debug
+		check.OutputFormat = test.format
+		check.AddMetric("rta", 24.558, "ms", "20", "50")
+		check.AddMetric("pl", 0, "%")
+		check.Final()
+
+		if exitHandler.code != WARNING {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, WARNING)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+		if debug.String() != test.expectedDebug {
+			t.Errorf("Got debug output: '%s', expected: '%s'", debug.String(), test.expectedDebug)
+		}
+	}
+}
+
+func TestFormatterUsingCheck(t *testing.T) {
+	defer func() {
+		formattersMu.Lock()
+		delete(formatters, "test")
+		formattersMu.Unlock()
+	}()
+
+	RegisterFormatter("test", func(check *Plugin, w io.Writer) error {
+		check.AddMessage("Formatted")
+		value, uom, _, _, _ := check.GetMetric("rta")
+		fmt.Fprintf(w, "%s rta=%v%s", check.StatusLine(), value, uom)
+		return nil
+	})
+
+	exitHandler := initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.OutputFormat = "test"
+	check.AddMetric("rta", 24.558, "ms")
+
+	done := make(chan bool)
+	go func() {
+		check.Final()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Got no exit, formatter deadlocked")
+	}
+
+	if exitHandler.output.String() != "OK: Formatted rta=24.558ms\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: Formatted rta=24.558ms\n'", exitHandler.output.String())
+	}
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// MetricFormat is the format of metrics written by WriteMetrics
+type MetricFormat int
+
+// Metric formats supported by WriteMetrics
+const (
+	// Nagios performance data, as in check output
+	MetricFormatNagios MetricFormat = iota
+	// Graphite plaintext protocol - "prefix.name value timestamp" lines
+	MetricFormatGraphite
+)
+
+var graphiteInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// graphiteName converts metric name to Graphite path with prefix, characters
+// other than letters, digits, "_", "-" and "." are replaced with "_"
+func graphiteName(prefix, name string) string {
+	name = graphiteInvalidChars.ReplaceAllString(name, "_")
+	prefix = strings.TrimSuffix(prefix, ".")
+	if len(prefix) > 0 {
+		return prefix + "." + name
+	}
+	return name
+}
+
+/*
+WriteMetrics writes metrics in the format, either as Nagios performance data
+(on a single line, as in check output) or as Graphite plaintext protocol
+lines, with metric names prefixed with MetricPrefix and sanitized for
+Graphite. Graphite timestamps are the current time, undetermined metrics are
+skipped.
+
+	check.MetricPrefix = "nagios." + hostname + ".http"
+	conn, _ := net.Dial("tcp", "graphite:2003")
+	check.WriteMetrics(conn, plugin.MetricFormatGraphite)
+
+*/
+func (p *Plugin) WriteMetrics(w io.Writer, format MetricFormat) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+
+	switch format {
+	case MetricFormatNagios:
+		if tokens := p.perfDataTokens(); len(tokens) > 0 {
+			fmt.Fprintf(&b, "%s\n", strings.Join(tokens, p.PerfDataSeparator))
+		}
+	case MetricFormatGraphite:
+		now := pNow().Unix()
+		for _, name := range p.sortedMetricNames() {
+			m := p.metrics[name]
+			val, err := i2f(m.value)
+			if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+				continue
+			}
+			fmt.Fprintf(&b, "%s %s %d\n", graphiteName(p.MetricPrefix, name), formatPerfDataValue(m.value, p.MetricPrecision), now)
+		}
+	default:
+		return fmt.Errorf("Invalid metric format: %d", format)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	defer func() { pNow = time.Now }()
+	pNow = func() time.Time { return time.Unix(1500000000, 0) }
+
+	tests := []struct {
+		prefix   string
+		format   MetricFormat
+		expected string
+		err      string
+	}{
+		{"", MetricFormatNagios, "'disk /var'=85%;80;90;0;100 'it''s'=1;;;; load=0.5;;;; rta=1000000ms;;;; uptime=U;;;;\n", ""},
+		{"web01.http", MetricFormatNagios, "'disk /var'=85%;80;90;0;100 'it''s'=1;;;; load=0.5;;;; rta=1000000ms;;;; uptime=U;;;;\n", ""},
+		{
+			"", MetricFormatGraphite,
+			"disk__var 85 1500000000\nit_s 1 1500000000\nload 0.5 1500000000\nrta 1000000 1500000000\n", "",
+		},
+		{
+			"nagios.web01.http.", MetricFormatGraphite,
+			"nagios.web01.http.disk__var 85 1500000000\nnagios.web01.http.it_s 1 1500000000\n" +
+				"nagios.web01.http.load 0.5 1500000000\nnagios.web01.http.rta 1000000 1500000000\n", "",
+		},
+		{"", MetricFormat(5), "", "Invalid metric format: 5"},
+	}
+
+	for _, test := range tests {
+		initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.EmptyValueAsUnknown = true
+		check.MetricPrefix = test.prefix
+		check.AddMetric("disk /var", 85, "%", "80", "90")
+		check.AddMetric("it's", "1")
+		check.AddMetric("load", 0.5)
+		check.AddMetric("rta", 1e6, "ms")
+		check.AddMetric("uptime", "")
+
+		var b bytes.Buffer
+		err := check.WriteMetrics(&b, test.format)
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("Got: '%s', expected: '%s'", b.String(), test.expected)
+		}
+	}
+}
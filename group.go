@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stat is an aggregate function used to summarize a group of metrics
+type Stat int
+
+// Supported aggregate functions
+const (
+	StatMax Stat = iota
+	StatMin
+	StatAvg
+	StatSum
+)
+
+// String returns aggregate function name
+func (s Stat) String() string {
+	switch s {
+	case StatMax:
+		return "max"
+	case StatMin:
+		return "min"
+	case StatAvg:
+		return "avg"
+	case StatSum:
+		return "sum"
+	default:
+		return "unknown"
+	}
+}
+
+// compute returns the aggregate function of values, which must not be empty
+func (s Stat) compute(values []float64) float64 {
+	result := values[0]
+	for _, v := range values[1:] {
+		switch s {
+		case StatMax:
+			if v > result {
+				result = v
+			}
+		case StatMin:
+			if v < result {
+				result = v
+			}
+		case StatAvg, StatSum:
+			result += v
+		}
+	}
+	if s == StatAvg {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+/*
+AddGroupSummary adds metric named "<prefix>_<stat>" (e.g. "disk_max") with
+the value computed over all previously added metrics which names start with
+prefix followed by "_" (e.g. "disk_1_usage", but not "diskio"). Undetermined
+and other summary metrics are ignored. The uom is kept if it is the same for
+all metrics in the group. The optional arguments are warning and critical
+thresholds, as in AddMetric.
+
+	check.AddMetric("disk_1_usage", 45, "%")
+	check.AddMetric("disk_2_usage", 91, "%")
+	check.AddGroupSummary("disk", plugin.StatMax, "80", "90")
+
+*/
+func (p *Plugin) AddGroupSummary(prefix string, stat Stat, args ...string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("Too many arguments")
+	}
+
+	p.mu.Lock()
+	err := p.addGroupSummary(prefix, stat, args...)
+	p.mu.Unlock()
+	return p.exitOnInvalidMessage(err)
+}
+
+// addGroupSummary adds summary metric of group, p.mu must be held
+func (p *Plugin) addGroupSummary(prefix string, stat Stat, args ...string) error {
+	var values []float64
+	var uom string
+
+	for _, name := range p.sortedMetricNames() {
+		metric := p.metrics[name]
+		if metric.summary || !strings.HasPrefix(name, prefix+"_") {
+			continue
+		}
+		val, err := i2f(metric.value)
+		if err != nil {
+			continue
+		}
+
+		if len(values) == 0 {
+			uom = metric.uom
+		} else if uom != metric.uom {
+			uom = ""
+		}
+		values = append(values, val)
+	}
+
+	switch {
+	case len(values) == 0:
+		return fmt.Errorf("No metrics matching %s", prefix)
+	case stat < StatMax || stat > StatSum:
+		return fmt.Errorf("Invalid aggregate function %d", stat)
+	}
+
+	name := prefix + "_" + stat.String()
+	err := p.addMetricArgs(name, stat.compute(values), append([]string{uom}, args...)...)
+	if metric, ok := p.metrics[name]; ok && (err == nil || err == errInvalidMessage) {
+		metric.summary = true
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestStatString(t *testing.T) {
+	tests := []struct {
+		in   Stat
+		text string
+	}{
+		{StatMax, "max"},
+		{StatMin, "min"},
+		{StatAvg, "avg"},
+		{StatSum, "sum"},
+		{Stat(10), "unknown"},
+	}
+
+	for _, test := range tests {
+		out := test.in.String()
+		if test.text != out {
+			t.Errorf("Got %s, expected %s", out, test.text)
+		}
+	}
+}
+
+type GroupSummaryArgs struct {
+	prefix    string
+	stat      Stat
+	threshold []string
+	err       string
+}
+
+func TestAddGroupSummary(t *testing.T) {
+	tests := []struct {
+		summaries        []GroupSummaryArgs
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			[]GroupSummaryArgs{
+				{"disk", StatMax, nil, ""},
+			},
+			OK, "OK: | cpu=10;;;; disk_1_usage=45%;;;0;100 disk_2_usage=91%;;;0;100 disk_3_usage=20%;;;0;100 disk_max=91%;;;0;100 diskio=500;;;;\n",
+		},
+		{
+			[]GroupSummaryArgs{
+				{"disk", StatMin, nil, ""},
+				{"disk", StatSum, nil, ""},
+				{"disk", StatAvg, nil, ""},
+			},
+			OK, "OK: | cpu=10;;;; disk_1_usage=45%;;;0;100 disk_2_usage=91%;;;0;100 disk_3_usage=20%;;;0;100 disk_avg=52%;;;0;100 disk_min=20%;;;0;100 disk_sum=156%;;;0;100 diskio=500;;;;\n",
+		},
+		{
+			[]GroupSummaryArgs{
+				{"disk", StatMax, []string{"80", "90"}, ""},
+			},
+			CRITICAL, "CRITICAL: disk_max is 91% (outside 90) | cpu=10;;;; disk_1_usage=45%;;;0;100 disk_2_usage=91%;;;0;100 disk_3_usage=20%;;;0;100 disk_max=91%;80;90;0;100 diskio=500;;;;\n",
+		},
+		{
+			[]GroupSummaryArgs{
+				{"net", StatMax, nil, "No metrics matching net"},
+				{"disk", Stat(10), nil, "Invalid aggregate function 10"},
+				{"disk", StatMax, []string{"1", "2", "3"}, "Too many arguments"},
+			},
+			OK, "OK: | cpu=10;;;; disk_1_usage=45%;;;0;100 disk_2_usage=91%;;;0;100 disk_3_usage=20%;;;0;100 diskio=500;;;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.AddMetric("disk_1_usage", 45, "%")
+			check.AddMetric("disk_2_usage", 91, "%")
+			check.AddMetric("disk_3_usage", 20, "%")
+			check.AddMetric("cpu", 10)
+			check.AddMetric("diskio", 500)
+			for _, s := range test.summaries {
+				err := check.AddGroupSummary(s.prefix, s.stat, s.threshold...)
+				if s.err == "" && err != nil {
+					t.Errorf("Got error: '%s', expected none", err)
+				}
+				if s.err != "" && (err == nil || err.Error() != s.err) {
+					t.Errorf("Got error: '%v', expected: '%s'", err, s.err)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
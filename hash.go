@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+/*
+OutputHash returns SHA-256 hex digest of the check status and messages,
+which can be used to suppress duplicate notifications. The final adjustments
+(e.g. EscalateWarningToCritical) are applied as in Final. Volatile parts of
+the output - check duration and, unless HashIncludesPerfData is set,
+performance data - are not included.
+
+	if check.OutputHash() == previousHash {
+		// same alert as before
+	}
+
+*/
+func (p *Plugin) OutputHash() string {
+	c := p.finalCopy()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := sha256.New()
+	c.renderSummary(h)
+	if c.HashIncludesPerfData {
+		if tokens := c.perfDataTokens(); len(tokens) > 0 {
+			fmt.Fprintf(h, " | %s", strings.Join(tokens, " "))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
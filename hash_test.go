@@ -0,0 +1,46 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestOutputHash(t *testing.T) {
+	hash := func(includePerfData bool, status Status, message string, value float64) string {
+		check := New("check_plugin", "v1.0")
+		check.HashIncludesPerfData = includePerfData
+		check.ShowDurationInMessage = true
+		check.AddResult(status, message)
+		check.AddMetric("m1", value)
+		return check.OutputHash()
+	}
+
+	h := hash(false, OK, "All ok", 1)
+	// sha256("OK: All ok")
+	if h != "0ff29d9ef5dc19cfcf9a7965f56096d65358c2711e12e1546053cf3b4c99966e" {
+		t.Errorf("Got hash: '%s', expected: '0ff29d9ef5dc19cfcf9a7965f56096d65358c2711e12e1546053cf3b4c99966e'", h)
+	}
+	if h != hash(false, OK, "All ok", 2) {
+		t.Errorf("Got different hashes for different metric values with perfdata excluded")
+	}
+	if h == hash(false, WARNING, "All ok", 1) {
+		t.Errorf("Got the same hash for different status")
+	}
+	if h == hash(false, OK, "Still ok", 1) {
+		t.Errorf("Got the same hash for different message")
+	}
+	if hash(true, OK, "All ok", 1) == hash(true, OK, "All ok", 2) {
+		t.Errorf("Got the same hashes for different metric values with perfdata included")
+	}
+	if hash(true, OK, "All ok", 1) != hash(true, OK, "All ok", 1) {
+		t.Errorf("Got different hashes for the same output with perfdata included")
+	}
+
+	// hash of the final output
+	check := New("check_plugin", "v1.0")
+	check.EscalateWarningToCritical = true
+	check.AddResult(WARNING, "All ok")
+	check.AddMetric("m1", 1)
+	if check.OutputHash() != hash(false, CRITICAL, "All ok", 1) {
+		t.Errorf("Got hash different from hash of escalated status")
+	}
+}
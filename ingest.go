@@ -0,0 +1,278 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// pluginStatusRe matches status of plugin output summary, optionally prefixed
+// with service name, e.g. "OK: message" or "DISK WARNING - message"
+var pluginStatusRe = regexp.MustCompile(`^(?:\S+\s+)?(OK|WARNING|CRITICAL|UNKNOWN)(?:\s*[:-]\s*|\s+|$)`)
+
+/*
+IngestPluginOutput parses output of another plugin (e.g. run as subprocess by
+a wrapper plugin) and merges it into the check - the status is folded with
+UpdateStatus, the message is appended, long output lines are added to the
+check long output and performance data are imported as metrics, as they are
+(without checking values against thresholds, min and max are kept). Both
+single line and multi-line output, with performance data following the long
+output after "|", are supported. If the output cannot be parsed nothing is
+merged and an error is returned.
+
+	out, _ := exec.Command("/usr/lib/nagios/plugins/check_ping", args...).Output()
+	if _, err := check.IngestPluginOutput(string(out)); err != nil {
+		check.ExitUnknown("Invalid check_ping output: %s", err)
+	}
+
+*/
+func (p *Plugin) IngestPluginOutput(raw string) (Status, error) {
+	summary, long, perfData := splitPluginOutput(raw)
+
+	m := pluginStatusRe.FindStringSubmatch(summary)
+	if m == nil {
+		return UNKNOWN, fmt.Errorf("Invalid plugin output status: %s", summary)
+	}
+	status := parseStatusName(m[1])
+	message := strings.TrimSpace(summary[len(m[0]):])
+
+	type metric struct {
+		key            string
+		value, uom     string
+		warn, critical string
+		bounds         [2]string
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var metrics []metric
+	keys := map[string]bool{}
+	for _, token := range splitPerfData(perfData) {
+		label, fields, err := parsePerfDataToken(token)
+		if err != nil {
+			return UNKNOWN, err
+		}
+		key := metricKey(label)
+		if _, ok := p.metrics[key]; ok || keys[key] {
+			return UNKNOWN, fmt.Errorf("Duplicated metric %s", quoteLabel(key))
+		}
+		keys[key] = true
+		value, uom := splitValueUOM(fields[0])
+		metrics = append(metrics, metric{key, value, uom, fields[1], fields[2], [2]string{fields[3], fields[4]}})
+	}
+
+	p.updateStatus(status)
+	if len(message) > 0 {
+		p.appendMessage(message)
+	}
+	p.longLines = append(p.longLines, long...)
+	for _, m := range metrics {
+		metric := &checkMetric{
+			value:    m.value,
+			uom:      m.uom,
+			warn:     m.warn,
+			critical: m.critical,
+			integer:  isInteger(m.value),
+		}
+		metric.setBounds(m.bounds)
+		p.metrics[m.key] = metric
+	}
+	return status, nil
+}
+
+/*
+AddMetricFromLine adds metric from performance data token, e.g. received on
+stdin from another tool. The token is validated as in IngestPluginOutput, but
+the metric is added with AddMetric, so its value is checked against the
+thresholds and the check status updated. Omitted trailing fields are handled
+as omitted AddMetric arguments.
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := check.AddMetricFromLine(scanner.Text()); err != nil {
+			check.ExitUnknown("Invalid input: %s", err)
+		}
+	}
+
+*/
+func (p *Plugin) AddMetricFromLine(line string) error {
+	label, fields, err := parsePerfDataToken(strings.TrimSpace(line))
+	if err != nil {
+		return err
+	}
+	value, uom := splitValueUOM(fields[0])
+	args := append([]string{uom}, fields[1:]...)
+	for len(args) > 0 && len(args[len(args)-1]) == 0 {
+		args = args[:len(args)-1]
+	}
+
+	if value == "U" {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.metrics[label]; ok {
+			return fmt.Errorf("Duplicated metric %s", quoteLabel(label))
+		}
+		return p.addUndeterminedMetric(label, args...)
+	}
+	return p.AddMetric(quoteLabel(label), value, args...)
+}
+
+/*
+ValidateOutput checks that plugin output (single or multi-line) complies with
+Monitoring Plugins Development Guidelines - the summary starts with a valid
+status, the text contains no control characters and performance data tokens
+are well-formed and unique. All violations found are returned.
+
+	out, _ := exec.Command("./check_service", "-H", "localhost").Output()
+	for _, err := range plugin.ValidateOutput(string(out)) {
+		t.Error(err)
+	}
+
+*/
+func ValidateOutput(s string) []error {
+	var errs []error
+	if len(strings.TrimSpace(s)) == 0 {
+		return append(errs, fmt.Errorf("Empty output"))
+	}
+
+	summary, long, perfData := splitPluginOutput(s)
+	if !pluginStatusRe.MatchString(summary) {
+		errs = append(errs, fmt.Errorf("Invalid status in %s", summary))
+	}
+	for i, text := range append([]string{summary}, long...) {
+		if j := strings.IndexFunc(text, isControlRune); j >= 0 {
+			errs = append(errs, fmt.Errorf("Invalid character %q in line %d", text[j], i+1))
+		}
+	}
+
+	labels := map[string]bool{}
+	for _, token := range splitPerfData(perfData) {
+		label, _, err := parsePerfDataToken(token)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if labels[label] {
+			errs = append(errs, fmt.Errorf("Duplicated label in %s", token))
+		}
+		labels[label] = true
+	}
+	return errs
+}
+
+// isControlRune returns true for control characters other than tab
+func isControlRune(r rune) bool {
+	return r < ' ' && r != '\t' || r == 0x7f
+}
+
+// splitPluginOutput splits plugin output into the summary (the first line
+// text), long output lines and performance data (following "|" on the first
+// line and after the long output, joined with space)
+func splitPluginOutput(raw string) (string, []string, string) {
+	lines := strings.Split(strings.TrimRight(raw, "\n"), "\n")
+
+	summary, perfData := splitPipe(lines[0])
+	var long []string
+	inPerfData := false
+	for _, line := range lines[1:] {
+		if inPerfData {
+			perfData += " " + line
+			continue
+		}
+		text, perf := splitPipe(line)
+		if strings.ContainsRune(line, '|') {
+			perfData += " " + perf
+			inPerfData = true
+			if len(text) == 0 {
+				continue
+			}
+		}
+		long = append(long, text)
+	}
+	return summary, long, perfData
+}
+
+// parseStatusName returns status for its name
+func parseStatusName(name string) Status {
+	for _, st := range []Status{OK, WARNING, CRITICAL} {
+		if st.String() == name {
+			return st
+		}
+	}
+	return UNKNOWN
+}
+
+// splitPipe splits line at the first "|" into text and performance data
+func splitPipe(line string) (string, string) {
+	i := strings.IndexRune(line, '|')
+	if i < 0 {
+		return strings.TrimSpace(line), ""
+	}
+	return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+}
+
+// splitPerfData splits performance data into tokens separated by
+// whitespace, keeping whitespace in quoted labels
+func splitPerfData(perfData string) []string {
+	var tokens []string
+	var token strings.Builder
+	quoted := false
+	for _, r := range perfData {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			token.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// parsePerfDataToken validates performance data token (trailing fields may
+// be omitted) and returns its label and value, warning, critical, min and
+// max fields
+func parsePerfDataToken(token string) (string, []string, error) {
+	i := strings.LastIndex(token, "=")
+	if i < 0 {
+		return "", nil, fmt.Errorf("Invalid label in %s", token)
+	}
+	fields := strings.Split(token[i+1:], ";")
+	if len(fields) > 5 {
+		return "", nil, fmt.Errorf("Invalid number of fields in %s", token)
+	}
+	for len(fields) < 5 {
+		fields = append(fields, "")
+	}
+	if err := validatePerfDataToken(token[:i+1] + strings.Join(fields, ";")); err != nil {
+		return "", nil, err
+	}
+
+	label := token[:i]
+	if strings.HasPrefix(label, "'") {
+		label = strings.Replace(label[1:len(label)-1], "''", "'", -1)
+	}
+	return label, fields, nil
+}
+
+// splitValueUOM splits performance data value into number and uom
+func splitValueUOM(value string) (string, string) {
+	if value == "U" {
+		return value, ""
+	}
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.-+eE", r)
+	})
+	if i < 0 {
+		return value, ""
+	}
+	return value[:i], value[i:]
+}
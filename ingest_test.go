@@ -0,0 +1,167 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestIngestPluginOutput(t *testing.T) {
+	tests := []struct {
+		raw            string
+		expectedStatus Status
+		expectedError  string
+		expectedOutput string
+	}{
+		{
+			"OK: All good\n",
+			OK, "",
+			"OK: Local ok, All good | local=1;;;;\n",
+		},
+		{
+			"PING WARNING - Packet loss = 20%, RTA = 24.56 ms|rta=24.558ms;50;100;0 pl=20%;10;50;0;100\n",
+			WARNING, "",
+			"WARNING: Local ok, Packet loss = 20%, RTA = 24.56 ms | local=1;;;; pl=20%;10;50;0;100 rta=24.558ms;50;100;0;\n",
+		},
+		{
+			"CRITICAL: 2 disks failing | '/var log'=95%;80;90 /=12\n/dev/sda1 failing\n/dev/sdb1 failing\n",
+			CRITICAL, "",
+			"CRITICAL: Local ok, 2 disks failing | /=12;;;; '/var log'=95%;80;90;; local=1;;;;\n/dev/sda1 failing\n/dev/sdb1 failing\n",
+		},
+		{
+			"UNKNOWN\nline 1\nline 2 | m1=1c\nm2=U;;\n",
+			UNKNOWN, "",
+			"UNKNOWN: Local ok | local=1;;;; m1=1c;;;; m2=U;;;;\nline 1\nline 2\n",
+		},
+		{
+			"Connection refused\n",
+			UNKNOWN, "Invalid plugin output status: Connection refused",
+			"OK: Local ok | local=1;;;;\n",
+		},
+		{
+			"CRITICAL: bad data | m1=abc;;;;\n",
+			UNKNOWN, "Invalid value in m1=abc;;;;",
+			"OK: Local ok | local=1;;;;\n",
+		},
+		{
+			"CRITICAL: duplicated | local=2\n",
+			UNKNOWN, "Duplicated metric local",
+			"OK: Local ok | local=1;;;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddResult(OK, "Local ok")
+		check.AddMetric("local", 1)
+
+		status, err := check.IngestPluginOutput(test.raw)
+		if status != test.expectedStatus {
+			t.Errorf("Got status: %s, expected: %s for %q", status, test.expectedStatus, test.raw)
+		}
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+
+		check.Final()
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestAddMetricFromLine(t *testing.T) {
+	tests := []struct {
+		line           string
+		expectedError  string
+		expectedCode   Status
+		expectedOutput string
+	}{
+		{"rta=24.558ms;50;100;0;", "", OK, "OK: | local=1;;;; rta=24.558ms;50;100;0;\n"},
+		{" used=95%;80;90 \n", "", CRITICAL, "CRITICAL: used is 95% (outside 90) | local=1;;;; used=95%;80;90;0;100\n"},
+		{"'/var log'=85;80;;", "", WARNING, "WARNING: '/var log' is 85 (outside 80) | '/var log'=85;80;;; local=1;;;;\n"},
+		{"load=0.5", "", OK, "OK: | load=0.5;;;; local=1;;;;\n"},
+		{"m1=U;;", "", OK, "OK: | local=1;;;; m1=U;;;;\n"},
+		{"m1=U;garbage", "Invalid warning threshold in m1=U;garbage;;;", OK, "OK: | local=1;;;;\n"},
+		{"m1=abc", "Invalid value in m1=abc;;;;", OK, "OK: | local=1;;;;\n"},
+		{"m1=1;20:10", "Invalid warning threshold in m1=1;20:10;;;", OK, "OK: | local=1;;;;\n"},
+		{"m1", "Invalid label in m1", OK, "OK: | local=1;;;;\n"},
+		{"local=2", "Duplicated metric local", OK, "OK: | local=1;;;;\n"},
+		{"local=U", "Duplicated metric local", OK, "OK: | local=1;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddMetric("local", 1)
+
+		err := check.AddMetricFromLine(test.line)
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s' for %q", err, test.expectedError, test.line)
+		}
+
+		check.Final()
+		if exitHandler.code != test.expectedCode {
+			t.Errorf("Got code: %d, expected: %d for %q", exitHandler.code, test.expectedCode, test.line)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for %q", exitHandler.output.String(), test.expectedOutput, test.line)
+		}
+	}
+}
+
+func TestSplitPerfData(t *testing.T) {
+	tokens := splitPerfData(" rta=1ms;2;3  'packet loss'=0%\t'it''s ok'=1 ")
+	expected := []string{"rta=1ms;2;3", "'packet loss'=0%", "'it''s ok'=1"}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Got tokens: %q, expected: %q", tokens, expected)
+	}
+	for i := range tokens {
+		if tokens[i] != expected[i] {
+			t.Errorf("Got token: %s, expected: %s", tokens[i], expected[i])
+		}
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	tests := []struct {
+		output         string
+		expectedErrors []string
+	}{
+		{"OK: All ok | rta=24.558ms;50;100;0; pl=0%\n", nil},
+		{"PING CRITICAL - Host down", nil},
+		{"WARNING: Disks\n/dev/sda1 full\n/dev/sdb1 ok | '/var log'=95%;80;90\n'/'=12;;;0;100\n", nil},
+		{"", []string{"Empty output"}},
+		{"FINE: All ok", []string{"Invalid status in FINE: All ok"}},
+		{"OK: All\x1b[1m ok", []string{"Invalid character '\\x1b' in line 1"}},
+		{"OK: Disks\nsda\r ok", []string{"Invalid character '\\r' in line 2"}},
+		{
+			"Broken | rta=abc;;;; 'pl=1 m1=1;;;;;; m2=1 m2=2",
+			[]string{
+				"Invalid status in Broken",
+				"Invalid value in rta=abc;;;;",
+				"Invalid label in 'pl=1 m1=1;;;;;; m2=1 m2=2;;;;",
+			},
+		},
+		{
+			"OK: All ok | m1=1;;;;;; m2=1 m2=2 m3=1;20:10",
+			[]string{
+				"Invalid number of fields in m1=1;;;;;;",
+				"Duplicated label in m2=2",
+				"Invalid warning threshold in m3=1;20:10;;;",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		errs := ValidateOutput(test.output)
+		if len(errs) != len(test.expectedErrors) {
+			t.Errorf("Got errors: %v, expected: %v for %q", errs, test.expectedErrors, test.output)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != test.expectedErrors[i] {
+				t.Errorf("Got error: '%s', expected: '%s'", err, test.expectedErrors[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// openMetricsUnit returns OpenMetrics unit of uom and function converting
+// values to it, the unit is empty if uom does not map to a known unit
+func (p *Plugin) openMetricsUnit(uom string) (string, func(float64) float64) {
+	identity := func(v float64) float64 { return v }
+	divide := func(d float64) func(float64) float64 {
+		return func(v float64) float64 { return v / d }
+	}
+
+	switch uom {
+	case "s":
+		return "seconds", identity
+	case "ms":
+		return "seconds", divide(1e3)
+	case "us":
+		return "seconds", divide(1e6)
+	case "ns":
+		return "seconds", divide(1e9)
+	case "%":
+		return "percent", identity
+	}
+	if strings.HasSuffix(uom, "B") {
+		if m, err := parseUnitValue("1"+uom, p.BinaryUnitPrefixes); err == nil {
+			return "bytes", func(v float64) float64 { return v * m }
+		}
+	}
+	return "", identity
+}
+
+/*
+WriteOpenMetrics writes check status and metrics in OpenMetrics text format,
+terminated with "# EOF" line. Metrics with time ("s", "ms", "us", "ns"),
+size ("B", "KB", "MiB", ...) and percentage uom are converted to seconds,
+bytes and percent respectively, with the unit added to metric name and as
+UNIT metadata. Counters ("c" uom) are written as counters, other metrics as
+gauges. Undetermined metrics are skipped.
+
+	w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	check.WriteOpenMetrics(w)
+
+*/
+func (p *Plugin) WriteOpenMetrics(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	p.writeExposition(&b, p.writeOpenMetricsMetric)
+	b.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeOpenMetricsMetric writes metric family in OpenMetrics text format, see
+// WriteOpenMetrics
+func (p *Plugin) writeOpenMetricsMetric(w io.Writer, name string, m *checkMetric, val float64) {
+	family := prometheusName(name)
+	unit, convert := p.openMetricsUnit(m.uom)
+	if len(unit) > 0 && !strings.HasSuffix(family, "_"+unit) {
+		family += "_" + unit
+	}
+
+	if m.uom == "c" {
+		fmt.Fprintf(w, "# TYPE %s counter\n", family)
+		fmt.Fprintf(w, "%s_total %v\n", family, val)
+		return
+	}
+	fmt.Fprintf(w, "# TYPE %s gauge\n", family)
+	if len(unit) > 0 {
+		fmt.Fprintf(w, "# UNIT %s %s\n", family, unit)
+	}
+	fmt.Fprintf(w, "%s %v\n", family, convert(val))
+}
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	initExitHandler()
+
+	check := New("check-service", "v1.0")
+	check.AllowCustomUOM = true
+	check.EmptyValueAsUnknown = true
+	check.AddMetric("rta", 24.558, "ms", "20", "50")
+	check.AddMetric("packet loss", 0, "%")
+	check.AddMetric("jitter", "")
+	check.AddMetric("uptime", 3600, "s")
+	check.AddMetric("used", 1.5, "GiB")
+	check.AddMetric("free", 200, "MB")
+	check.AddMetric("requests", 1234, "c")
+	check.AddMetric("load1", 0.7)
+	check.AddMetric("temp", 21.5, "C")
+
+	var b bytes.Buffer
+	if err := check.WriteOpenMetrics(&b); err != nil {
+		t.Fatalf("Got error: %s, expected none", err)
+	}
+
+	expected := `# TYPE check_service_status gauge
+check_service_status 1
+# TYPE free_bytes gauge
+# UNIT free_bytes bytes
+free_bytes 2e+08
+# TYPE load1 gauge
+load1 0.7
+# TYPE packet_loss_percent gauge
+# UNIT packet_loss_percent percent
+packet_loss_percent 0
+# TYPE requests counter
+requests_total 1234
+# TYPE rta_seconds gauge
+# UNIT rta_seconds seconds
+rta_seconds 0.024558
+# TYPE temp gauge
+temp 21.5
+# TYPE uptime_seconds gauge
+# UNIT uptime_seconds seconds
+uptime_seconds 3600
+# TYPE used_bytes gauge
+# UNIT used_bytes bytes
+used_bytes 1.610612736e+09
+# EOF
+`
+	if b.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", b.String(), expected)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Errorf("Got last line: '%s', expected: '# EOF'", lines[len(lines)-1])
+	}
+	if strings.Count(b.String(), "# EOF") != 1 {
+		t.Errorf("Got %d EOF lines, expected 1", strings.Count(b.String(), "# EOF"))
+	}
+}
@@ -0,0 +1,45 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var passiveEscaper = strings.NewReplacer("\r", "", "\n", `\n`)
+
+/*
+WritePassiveResult writes the check result as Nagios external command
+submitting passive service check result:
+
+	[timestamp] PROCESS_SERVICE_CHECK_RESULT;host;service;code;output
+
+The output is the check output as printed by Final (in Nagios format), with
+new lines, e.g. of long output, escaped as "\n". Host and service cannot
+contain semicolons nor new lines.
+
+	f, err := os.OpenFile("/var/lib/nagios/rw/nagios.cmd", os.O_WRONLY, 0)
+	if err == nil {
+		check.WritePassiveResult(f, "web01", "HTTP")
+	}
+
+*/
+func (p *Plugin) WritePassiveResult(w io.Writer, host, service string) error {
+	for _, field := range []string{host, service} {
+		if len(field) == 0 || strings.ContainsAny(field, ";\r\n") {
+			return fmt.Errorf("Invalid host or service name: %q", field)
+		}
+	}
+
+	c := p.finalCopy()
+	c.mu.Lock()
+	var output bytes.Buffer
+	c.render(&output)
+	status := c.status
+	c.mu.Unlock()
+
+	_, err := fmt.Fprintf(w, "[%d] PROCESS_SERVICE_CHECK_RESULT;%s;%s;%d;%s\n",
+		pNow().Unix(), host, service, status.ExitCode(), passiveEscaper.Replace(output.String()))
+	return err
+}
@@ -0,0 +1,64 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWritePassiveResult(t *testing.T) {
+	pNow = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() { pNow = time.Now }()
+
+	tests := []struct {
+		host           string
+		service        string
+		messages       []string
+		long           []string
+		err            string
+		expectedOutput string
+	}{
+		{
+			"web01", "HTTP", []string{"All ok"}, nil, "",
+			"[1500000000] PROCESS_SERVICE_CHECK_RESULT;web01;HTTP;1;WARNING: All ok, m1 is 123.456 (outside 100) | m1=123.456;100;;;\n",
+		},
+		{
+			"web01", "HTTP", []string{"a;b", "line1\nline2"}, nil, "",
+			"[1500000000] PROCESS_SERVICE_CHECK_RESULT;web01;HTTP;1;WARNING: a;b, line1\\nline2, m1 is 123.456 (outside 100) | m1=123.456;100;;;\n",
+		},
+		{
+			"web01", "HTTP", []string{"All ok"}, []string{"disk /: 45%", "disk /var: 91%"}, "",
+			"[1500000000] PROCESS_SERVICE_CHECK_RESULT;web01;HTTP;1;WARNING: All ok, m1 is 123.456 (outside 100) | m1=123.456;100;;;\\ndisk /: 45%\\ndisk /var: 91%\n",
+		},
+		{
+			"web;01", "HTTP", nil, nil, "Invalid host or service name: \"web;01\"", "",
+		},
+		{
+			"web01", "", nil, nil, "Invalid host or service name: \"\"", "",
+		},
+	}
+
+	for _, test := range tests {
+		initExitHandler()
+		check := New("check_plugin", "v1.0")
+		for _, m := range test.messages {
+			check.AddMessage(m)
+		}
+		for _, l := range test.long {
+			check.AddLongMessage(l)
+		}
+		check.AddMetric("m1", 123.456, "", "100")
+
+		var b bytes.Buffer
+		err := check.WritePassiveResult(&b, test.host, test.service)
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+		if b.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", b.String(), test.expectedOutput)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+AddPercentile adds metric with value of the percentile (0-100) of samples,
+checked against warning and critical thresholds as in AddMetric. The
+percentile is linearly interpolated between the closest ranks. If there are
+no samples the metric is added as undetermined (U).
+
+	check.AddPercentile("rta_p95", latencies, 95, "ms", "200", "500")
+
+*/
+func (p *Plugin) AddPercentile(name string, samples []float64, percentile float64, uom, warn, crit string) error {
+	if math.IsNaN(percentile) || percentile < 0 || percentile > 100 {
+		return fmt.Errorf("Invalid percentile of %s: %v", quoteLabel(metricKey(name)), percentile)
+	}
+
+	if len(samples) == 0 {
+		p.mu.Lock()
+		err := p.addUndeterminedPercentile(name, uom, warn, crit)
+		p.mu.Unlock()
+		return p.exitOnInvalidMessage(err)
+	}
+
+	return p.AddMetric(name, percentileOf(samples, percentile), uom, warn, crit)
+}
+
+// addUndeterminedPercentile adds percentile metric without samples as
+// undetermined, p.mu must be held
+func (p *Plugin) addUndeterminedPercentile(name, uom, warn, crit string) error {
+	key := metricKey(name)
+	if _, err := NormalizeMetricName(key); err != nil {
+		return err
+	}
+	if _, ok := p.metrics[key]; ok {
+		return fmt.Errorf("Duplicated metric %s", quoteLabel(key))
+	}
+	return p.addUndeterminedMetric(key, uom, warn, crit)
+}
+
+// percentileOf returns the percentile of samples, linearly interpolated
+// between the closest ranks
+func percentileOf(samples []float64, percentile float64) float64 {
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+
+	rank := percentile / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
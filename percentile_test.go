@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPercentileOf(t *testing.T) {
+	samples := []float64{15, 20, 35, 40, 50, 10, 25, 30, 45, 5}
+
+	tests := []struct {
+		percentile float64
+		expected   float64
+	}{
+		{0, 5},
+		{50, 27.5},
+		{90, 45.5},
+		{95, 47.75},
+		{99, 49.55},
+		{100, 50},
+	}
+
+	for _, test := range tests {
+		got := percentileOf(samples, test.percentile)
+		if math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("Got p%v: %v, expected: %v", test.percentile, got, test.expected)
+		}
+	}
+
+	if got := percentileOf([]float64{1e-12, 3e-12}, 50); math.Abs(got-2e-12) > 1e-24 {
+		t.Errorf("Got p50 of small samples: %v, expected: 2e-12", got)
+	}
+	if got := percentileOf([]float64{42}, 95); got != 42 {
+		t.Errorf("Got p95 of single sample: %v, expected: 42", got)
+	}
+	if samples[0] != 15 {
+		t.Errorf("Got samples modified: %v", samples)
+	}
+}
+
+func TestAddPercentile(t *testing.T) {
+	samples := []float64{15, 20, 35, 40, 50, 10, 25, 30, 45, 5}
+
+	tests := []struct {
+		samples          []float64
+		percentile       float64
+		expectedError    string
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{samples, 50, "", OK, "OK: | p=27.5ms;40;48;;\n"},
+		{samples, 87.5, "", WARNING, "WARNING: p is 44.375ms (outside 40) | p=44.375ms;40;48;;\n"},
+		{samples, 99, "", CRITICAL, "CRITICAL: p is 49.55ms (outside 48) | p=49.55ms;40;48;;\n"},
+		{nil, 95, "", OK, "OK: | p=U;40;48;;\n"},
+		{samples, 101, "Invalid percentile of p: 101", OK, "OK:\n"},
+		{samples, -1, "Invalid percentile of p: -1", OK, "OK:\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+
+		err := check.AddPercentile("p", test.samples, test.percentile, "ms", "40", "48")
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+
+		check.Final()
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	if err := check.AddPercentile("p", nil, 95, "ms", "a", ""); err == nil || err.Error() != "Invalid format of warning threshold p: a" {
+		t.Errorf("Got error: %v, expected: 'Invalid format of warning threshold p: a'", err)
+	}
+	check.AddPercentile("p", nil, 95, "ms", "", "")
+	if err := check.AddPercentile("p", samples, 95, "ms", "", ""); err == nil || err.Error() != "Duplicated metric p" {
+		t.Errorf("Got error: %v, expected: 'Duplicated metric p'", err)
+	}
+}
@@ -0,0 +1,281 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// metricKey returns metric name with surrounding quotes removed (and inner
+// doubled quotes unescaped), metrics are stored (and checked for duplicates)
+// by unquoted names
+func metricKey(name string) string {
+	if len(name) >= 2 && strings.HasPrefix(name, "'") && strings.HasSuffix(name, "'") {
+		return strings.Replace(name[1:len(name)-1], "''", "'", -1)
+	}
+	return name
+}
+
+// quoteLabel returns metric name quoted for use in check output
+func quoteLabel(name string) string {
+	if strings.ContainsAny(name, " ='") {
+		return "'" + strings.Replace(name, "'", "''", -1) + "'"
+	}
+	return name
+}
+
+/*
+NormalizeMetricName returns metric name as label used in performance data.
+Names containing space, "=" or "'" are quoted, with quotes inside doubled.
+Names already quoted are normalized the same way. Names which cannot be
+represented in performance data - empty or containing control characters or
+"|" (the performance data separator) - are rejected. AddMetric applies the
+same rules to metric names.
+
+	label, err := plugin.NormalizeMetricName("it's used") // 'it''s used'
+
+*/
+func NormalizeMetricName(name string) (string, error) {
+	key := metricKey(name)
+	if len(key) == 0 {
+		return "", fmt.Errorf("Invalid metric name, empty")
+	}
+	if strings.IndexFunc(key, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("Invalid metric name %q, contains control character", name)
+	}
+	if strings.ContainsRune(key, '|') {
+		return "", fmt.Errorf("Invalid metric name %q, contains perfdata separator", name)
+	}
+	return quoteLabel(key), nil
+}
+
+// perfData returns metric serialized as performance data token, float values
+// are rounded to precision significant digits (see MetricPrecision)
+func (m *checkMetric) perfData(name string, precision int) string {
+	return fmt.Sprintf("%s=%s%s;%s;%s;%s;%s",
+		quoteLabel(name),
+		formatPerfDataValue(m.value, precision),
+		m.uom,
+		m.warn,
+		m.critical,
+		m.min,
+		m.max,
+	)
+}
+
+// formatPerfDataValue formats metric value for performance data - integers as
+// they are, floats in decimal notation (never with exponent) rounded to
+// precision significant digits if precision is positive, otherwise with the
+// shortest representation
+func formatPerfDataValue(value interface{}, precision int) string {
+	var f float64
+	bitSize := 64
+	switch v := value.(type) {
+	case float32:
+		f, bitSize = float64(v), 32
+	case float64:
+		f = v
+	default:
+		return fmt.Sprint(value)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Sprint(value)
+	}
+
+	if precision > 0 {
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'e', precision-1, 64), 64)
+		bitSize = 64
+	}
+	return strconv.FormatFloat(f, 'f', -1, bitSize)
+}
+
+// perfDataTokens returns performance data tokens of all metrics, sorted by
+// name, skipping metrics with absolute value lower than MinMetricValue and
+// metrics not whitelisted (see PerfDataWhitelist)
+func (p *Plugin) perfDataTokens() []string {
+	names := p.sortedMetricNames()
+	tokens := make([]string, 0, len(names))
+	for _, k := range names {
+		if len(p.perfDataWhitelist) > 0 && !p.perfDataWhitelist[k] {
+			continue
+		}
+		if val, err := i2f(p.metrics[k].value); err == nil && math.Abs(val) < p.MinMetricValue {
+			continue
+		}
+		tokens = append(tokens, p.metrics[k].perfData(k, p.MetricPrecision))
+	}
+	if p.perfDataTruncated && len(tokens) > p.perfDataKeep {
+		tokens = tokens[:p.perfDataKeep]
+	}
+	return tokens
+}
+
+// applyPerfDataBudget truncates performance data at metric boundary to fit
+// PerfDataByteBudget, escalating the check to WARNING if truncated
+func (p *Plugin) applyPerfDataBudget() {
+	if p.PerfDataByteBudget <= 0 {
+		return
+	}
+	p.mu.Lock()
+	tokens := p.perfDataTokens()
+	p.mu.Unlock()
+	if len(strings.Join(tokens, p.PerfDataSeparator)) <= p.PerfDataByteBudget {
+		return
+	}
+
+	var size, keep int
+	for i, token := range tokens {
+		if i > 0 {
+			size += len(p.PerfDataSeparator)
+		}
+		size += len(token)
+		if size > p.PerfDataByteBudget {
+			break
+		}
+		keep++
+	}
+	p.mu.Lock()
+	p.perfDataKeep, p.perfDataTruncated = keep, true
+	p.mu.Unlock()
+	p.AddResult(WARNING, "perfdata truncated")
+}
+
+/*
+PerfDataWhitelist limits performance data to the listed metrics, other
+metrics are still checked against thresholds and affect the check status,
+but are omitted from the output. Empty list means all metrics are output.
+
+	check.PerfDataWhitelist([]string{"rta", "pl"})
+
+*/
+func (p *Plugin) PerfDataWhitelist(names []string) {
+	p.perfDataWhitelist = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.perfDataWhitelist[metricKey(name)] = true
+	}
+}
+
+/*
+ValidatePerfData checks that every metric renders to a performance data
+token compliant with Monitoring Plugins Development Guidelines - label is
+quoted properly, value is numeric (or "U"), uom is valid and thresholds are
+well-formed. The first violation found is returned.
+
+	if err := check.ValidatePerfData(); err != nil {
+		check.ExitUnknown("Invalid performance data: %s", err)
+	}
+
+*/
+func (p *Plugin) ValidatePerfData() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, token := range p.perfDataTokens() {
+		if err := validatePerfDataToken(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closingQuote returns index of the quote closing label quoted at the start
+// of token, skipping escaped (doubled) quotes, or -1 if the label is not
+// closed
+func closingQuote(token string) int {
+	for i := 1; i < len(token); i++ {
+		if token[i] != '\'' {
+			continue
+		}
+		if i+1 < len(token) && token[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func validatePerfDataToken(token string) error {
+	var label, rest string
+
+	if strings.HasPrefix(token, "'") {
+		end := closingQuote(token)
+		if end < 0 || !strings.HasPrefix(token[end+1:], "=") {
+			return fmt.Errorf("Invalid label in %s", token)
+		}
+		label, rest = token[1:end], token[end+2:]
+	} else {
+		i := strings.IndexRune(token, '=')
+		if i < 0 {
+			return fmt.Errorf("Invalid label in %s", token)
+		}
+		label, rest = token[:i], token[i+1:]
+		if strings.ContainsAny(label, " '=") {
+			return fmt.Errorf("Invalid label in %s", token)
+		}
+	}
+	if len(label) == 0 {
+		return fmt.Errorf("Invalid label in %s", token)
+	}
+
+	fields := strings.Split(rest, ";")
+	if len(fields) != 5 {
+		return fmt.Errorf("Invalid number of fields in %s", token)
+	}
+
+	value := fields[0]
+	numEnd := strings.IndexFunc(value, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.-+eE", r)
+	})
+	if numEnd < 0 {
+		numEnd = len(value)
+	}
+	if value != "U" {
+		if _, err := strconv.ParseFloat(value[:numEnd], 64); err != nil {
+			return fmt.Errorf("Invalid value in %s", token)
+		}
+		if !validUOM(value[numEnd:]) {
+			return fmt.Errorf("Invalid uom in %s", token)
+		}
+	}
+
+	for i, name := range []string{"warning", "critical"} {
+		if t := fields[i+1]; len(t) > 0 {
+			if _, err := parseThreshold(t); err != nil {
+				return fmt.Errorf("Invalid %s threshold in %s", name, token)
+			}
+		}
+	}
+	for i, name := range []string{"min", "max"} {
+		if v := fields[i+3]; len(v) > 0 {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fmt.Errorf("Invalid %s in %s", name, token)
+			}
+		}
+	}
+	return nil
+}
+
+// permittedUOMs are units permitted by Monitoring Plugins Development
+// Guidelines (and commonly accepted extensions)
+var permittedUOMs = map[string]bool{
+	"": true, "s": true, "ms": true, "us": true, "ns": true, "%": true,
+	"B": true, "KB": true, "MB": true, "GB": true, "TB": true, "c": true,
+}
+
+// permittedUOM checks if uom is one of permittedUOMs, or rate of one of them
+// (e.g. "B/s", see AddRateMetric)
+func permittedUOM(uom string) bool {
+	if permittedUOMs[uom] {
+		return true
+	}
+	base := strings.TrimSuffix(uom, rateSuffix)
+	return base != uom && permittedUOMs[base] && validRateUnit(base)
+}
+
+// validUOM checks if uom contains only characters allowed in performance
+// data unit of measurement
+func validUOM(uom string) bool {
+	return !strings.ContainsAny(uom, "0123456789;='\" \t\n")
+}
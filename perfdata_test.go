@@ -0,0 +1,357 @@
+package plugin
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeMetricName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		err      string
+	}{
+		{"m1", "m1", ""},
+		{"disk/usage", "disk/usage", ""},
+		{"white space", "'white space'", ""},
+		{"'white space'", "'white space'", ""},
+		{"a=b", "'a=b'", ""},
+		{"it's", "'it''s'", ""},
+		{"'it''s'", "'it''s'", ""},
+		{"it's here", "'it''s here'", ""},
+		{`C:\Program Files\it's`, `'C:\Program Files\it''s'`, ""},
+		{"'", "''''", ""},
+		{"a|b", "", `Invalid metric name "a|b", contains perfdata separator`},
+		{"''", "", "Invalid metric name, empty"},
+		{"", "", "Invalid metric name, empty"},
+		{"m\t1", "", `Invalid metric name "m\t1", contains control character`},
+		{"m\x001", "", `Invalid metric name "m\x001", contains control character`},
+	}
+
+	for _, test := range tests {
+		label, err := NormalizeMetricName(test.name)
+		if label != test.expected {
+			t.Errorf("Got label: '%s', expected: '%s'", label, test.expected)
+		}
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+		if err == nil {
+			if err := validatePerfDataToken(label + "=1;;;;"); err != nil {
+				t.Errorf("Got error: '%s' for label %s, expected none", err, label)
+			}
+		}
+	}
+
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	for _, name := range []string{"it's", "a=b", "'white space'"} {
+		if err := check.AddMetric(name, 1); err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+	}
+	if err := check.AddMetric("", 1); err == nil || err.Error() != "Invalid metric name, empty" {
+		t.Errorf("Got error: '%v', expected: 'Invalid metric name, empty'", err)
+	}
+	if err := check.AddMetric("'it''s'", 2); err == nil || err.Error() != "Duplicated metric 'it''s'" {
+		t.Errorf("Got error: '%v', expected: 'Duplicated metric 'it''s''", err)
+	}
+	expected := "'a=b'=1;;;; 'it''s'=1;;;; 'white space'=1;;;;"
+	if perfData := strings.Join(check.perfDataTokens(), " "); perfData != expected {
+		t.Errorf("Got perfdata: '%s', expected: '%s'", perfData, expected)
+	}
+}
+
+func TestMetricPrecision(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		precision int
+		expected  string
+	}{
+		{1000000, 0, "m=1000000;;;;"},
+		{int64(9007199254740993), 0, "m=9007199254740993;;;;"},
+		{uint64(18446744073709551615), 0, "m=18446744073709551615;;;;"},
+		{1000000.0, 0, "m=1000000;;;;"},
+		{1e21, 0, "m=1000000000000000000000;;;;"},
+		{0.00001, 0, "m=0.00001;;;;"},
+		{1487801591.176291383, 0, "m=1487801591.1762915;;;;"},
+		{1487801591.176291383, 12, "m=1487801591.18;;;;"},
+		{1487801591.176291383, 3, "m=1490000000;;;;"},
+		{float32(1.0001), 0, "m=1.0001;;;;"},
+		{float32(1.0001), 2, "m=1;;;;"},
+		{123.456, 4, "m=123.5;;;;"},
+		{1000000, 2, "m=1000000;;;;"},
+		{"1487801591.176291383", 3, "m=1487801591.176291383;;;;"},
+		{math.NaN(), 0, "m=NaN;;;;"},
+	}
+
+	for _, test := range tests {
+		initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.MetricPrecision = test.precision
+		if err := check.AddMetric("m", test.value); err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if perfData := strings.Join(check.perfDataTokens(), " "); perfData != test.expected {
+			t.Errorf("Got perfdata: '%s', expected: '%s' for %v with precision %d", perfData, test.expected, test.value, test.precision)
+		}
+	}
+}
+
+func TestValidatePerfDataToken(t *testing.T) {
+	tests := []struct {
+		token string
+		err   string
+	}{
+		{"m1=1;;;;", ""},
+		{"m1=-1.5e3MB;10;20:30;0;100", ""},
+		{"m1=U;;;;", ""},
+		{"'white space'=1;;;;", ""},
+		{"'it''s'=1;;;;", ""},
+		{"'a=b'=1;;;;", ""},
+		{"'a''=b'=1;;;;", ""},
+		{"'a'''=1;;;;", ""},
+		{"'m1=1;;;;", "Invalid label in 'm1=1;;;;"},
+		{"'it's'=1;;;;", "Invalid label in 'it's'=1;;;;"},
+		{"'a'b=1;;;;", "Invalid label in 'a'b=1;;;;"},
+		{"''=1;;;;", "Invalid label in ''=1;;;;"},
+		{"white space=1;;;;", "Invalid label in white space=1;;;;"},
+		{"=1;;;;", "Invalid label in =1;;;;"},
+		{"m1", "Invalid label in m1"},
+		{"m1=1;;;", "Invalid number of fields in m1=1;;;"},
+		{"m1=abc;;;;", "Invalid value in m1=abc;;;;"},
+		{"m1=1M B;;;;", "Invalid uom in m1=1M B;;;;"},
+		{"m1=1;a;;;", "Invalid warning threshold in m1=1;a;;;"},
+		{"m1=1;;20:10;;", "Invalid critical threshold in m1=1;;20:10;;"},
+		{"m1=1;;;a;", "Invalid min in m1=1;;;a;"},
+		{"m1=1;;;;b", "Invalid max in m1=1;;;;b"},
+	}
+
+	for _, test := range tests {
+		err := validatePerfDataToken(test.token)
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+	}
+}
+
+func TestValidatePerfData(t *testing.T) {
+	initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.EmptyValueAsUnknown = true
+	check.AddMetric("rta", 24.558, "ms", "20", "50")
+	check.AddMetric("packet loss", 0, "%")
+	check.AddMetric("jitter", "")
+	check.AddMetric("a'=b", 1)
+	if err := check.ValidatePerfData(); err != nil {
+		t.Errorf("Got error: '%s', expected none", err)
+	}
+
+	check.AllowCustomUOM = true
+	check.AddMetric("bad", 1, "M B")
+	if err := check.ValidatePerfData(); err == nil || err.Error() != "Invalid uom in bad=1M B;;;;" {
+		t.Errorf("Got error: '%v', expected: 'Invalid uom in bad=1M B;;;;'", err)
+	}
+}
+
+func TestMinMetricValue(t *testing.T) {
+	tests := []struct {
+		min              float64
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{0, WARNING, "WARNING: m2 is 0.001 (outside 10:) | m1=0;;;; m2=0.001;10:;;; m3=-0.5;;;; m4=12;;;; m5=U;;;;\n"},
+		{0.01, WARNING, "WARNING: m2 is 0.001 (outside 10:) | m3=-0.5;;;; m4=12;;;; m5=U;;;;\n"},
+		{100, WARNING, "WARNING: m2 is 0.001 (outside 10:) | m5=U;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.EmptyValueAsUnknown = true
+			check.MinMetricValue = test.min
+			check.AddMetric("m1", 0)
+			check.AddMetric("m2", 0.001, "", "10:")
+			check.AddMetric("m3", -0.5)
+			check.AddMetric("m4", 12)
+			check.AddMetric("m5", "")
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestPerfDataWhitelist(t *testing.T) {
+	tests := []struct {
+		whitelist        []string
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{nil, CRITICAL, "CRITICAL: m2 is 150 (outside 100) | 'm 3'=3;;;; m1=1;;;; m2=150;;100;;\n"},
+		{[]string{}, CRITICAL, "CRITICAL: m2 is 150 (outside 100) | 'm 3'=3;;;; m1=1;;;; m2=150;;100;;\n"},
+		{[]string{"m1"}, CRITICAL, "CRITICAL: m2 is 150 (outside 100) | m1=1;;;;\n"},
+		{[]string{"'m 3'", "m1", "m4"}, CRITICAL, "CRITICAL: m2 is 150 (outside 100) | 'm 3'=3;;;; m1=1;;;;\n"},
+		{[]string{"m4"}, CRITICAL, "CRITICAL: m2 is 150 (outside 100)\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.PerfDataWhitelist(test.whitelist)
+		check.AddMetric("m1", 1)
+		check.AddMetric("m2", 150, "", "", "100")
+		check.AddMetric("m 3", 3)
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestUOMBounds(t *testing.T) {
+	tests := []struct {
+		bounds         map[string][2]float64
+		expectedOutput string
+	}{
+		{
+			nil,
+			"OK: | load=0.7;;;; pl=12%;;;; temp=21.5C;;;;\n",
+		},
+		{
+			map[string][2]float64{"%": {0, 100}},
+			"OK: | load=0.7;;;; pl=12%;;;0;100 temp=21.5C;;;;\n",
+		},
+		{
+			map[string][2]float64{"%": {0, 100}, "C": {-273.15, 1000}, "": {0, 1.5}},
+			"OK: | load=0.7;;;0;1.5 pl=12%;;;0;100 temp=21.5C;;;-273.15;1000\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.UOMBounds = test.bounds
+		check.AllowCustomUOM = true
+		check.AddMetric("pl", 12, "%")
+		check.AddMetric("temp", 21.5, "C")
+		check.AddMetric("load", 0.7)
+		check.Final()
+
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+
+	exitHandler := initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.AddMetric("pl", 12, "%")
+	check.Final()
+	if exitHandler.output.String() != "OK: | pl=12%;;;0;100\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: | pl=12%%;;;0;100\n'", exitHandler.output.String())
+	}
+}
+
+func TestPerfDataByteBudget(t *testing.T) {
+	tests := []struct {
+		budget           int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{0, OK, "OK: All ok | m1=1;;;; m2=22;;;; m3=333;;;;\n"},
+		{100, OK, "OK: All ok | m1=1;;;; m2=22;;;; m3=333;;;;\n"},
+		{29, OK, "OK: All ok | m1=1;;;; m2=22;;;; m3=333;;;;\n"},
+		{28, WARNING, "WARNING: All ok, perfdata truncated | m1=1;;;; m2=22;;;;\n"},
+		{18, WARNING, "WARNING: All ok, perfdata truncated | m1=1;;;; m2=22;;;;\n"},
+		{17, WARNING, "WARNING: All ok, perfdata truncated | m1=1;;;;\n"},
+		{5, WARNING, "WARNING: All ok, perfdata truncated\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.PerfDataByteBudget = test.budget
+		check.AddMessage("All ok")
+		check.AddMetric("m3", 333)
+		check.AddMetric("m1", 1)
+		check.AddMetric("m2", 22)
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d for budget %d", exitHandler.code, test.expectedExitCode, test.budget)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestAllowCustomUOM(t *testing.T) {
+	tests := []struct {
+		uom           string
+		allowCustom   bool
+		expectedError string
+	}{
+		{"", false, ""},
+		{"s", false, ""},
+		{"ms", false, ""},
+		{"us", false, ""},
+		{"%", false, ""},
+		{"B", false, ""},
+		{"KB", false, ""},
+		{"MB", false, ""},
+		{"GB", false, ""},
+		{"TB", false, ""},
+		{"c", false, ""},
+		{"B/s", false, ""},
+		{"MB/s", false, ""},
+		{"ms/s", false, ""},
+		{"xyz/s", false, "Invalid UOM for m1: xyz/s"},
+		{"xyz/s", true, ""},
+		{"mb", false, "Invalid UOM for m1: mb"},
+		{"C", false, "Invalid UOM for m1: C"},
+		{"%/s", false, "Invalid UOM for m1: %/s"},
+		{"mb", true, ""},
+		{"C", true, ""},
+	}
+
+	for _, test := range tests {
+		initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AllowCustomUOM = test.allowCustom
+
+		err := check.AddMetric("m1", 1, test.uom)
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+		if _, ok := check.metrics["m1"]; ok != (len(test.expectedError) == 0) {
+			t.Errorf("Got metric stored: %v for uom %s", ok, test.uom)
+		}
+
+		err = check.AddMetricT("m2", 1, test.uom, nil, nil)
+		if err != nil && err.Error() != strings.Replace(test.expectedError, "m1", "m2", 1) || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+	}
+}
@@ -57,21 +57,81 @@ package plugin
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jessevdk/go-flags"
 	"io"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // Plugin represents the check - its name, version and help messages. It also
-// stores the check status, messages and metrics data.
+// stores the check status, messages and metrics data. Methods adding or
+// reading status, messages and metrics (and the output and exporters built
+// from them) may be called from multiple goroutines.
 type Plugin struct {
+	// guards status, messages and metrics of checks adding them from
+	// multiple goroutines
+	mu       *sync.Mutex
 	status   Status
 	messages []string
 	metrics  checkMetrics
+	bindings map[string]*thresholdBinding
+	debugBuf *bytes.Buffer
+	cleanups []func()
+	onFinal  []func(Status, string)
+	sinks    []func(*Plugin) error
+	started  time.Time
+	reqID    string
+	output   io.Writer
+	exitFunc func(Status)
+	// localized status labels, see SetStatusLabels
+	statusLabels map[Status]string
+	// names of metrics output in performance data, all if empty
+	perfDataWhitelist map[string]bool
+	// number of performance data tokens output if truncated, see
+	// PerfDataByteBudget
+	perfDataKeep      int
+	perfDataTruncated bool
+	// metric values stored by previous run and the state file, see
+	// WithMetricHistory
+	history     map[string]float64
+	historyFile string
+	// timer started by SetTimeout, whether it expired and whether the
+	// check output is being finished (so cleanups calling Exit* do not
+	// finish it again), guarded by timeoutMu
+	timer     *time.Timer
+	timedOut  bool
+	finishing bool
+	timeoutMu *sync.Mutex
+	// long output lines, see AddLongMessage
+	longLines []string
+	// number of -v flags given on the command line, see Verbosity
+	verbosity int
+	// status of result of each message, noResult for messages not added
+	// with AddResult
+	resultStatuses []Status
+	// number of OK results and the required minimum, see RequireMinOK
+	okResults    int
+	minOK        int
+	minOKMessage string
+	// warning and critical limits of messages count, see
+	// StatusFromMessageCount
+	messageCount *[2]int
+	// documentation URLs attached to results, see AddResultDoc
+	documentation []string
+	// whether the final adjustments were added by Final, so sinks rendering
+	// the output do not add them again
+	prepared bool
 	// Plugin name
 	Name string
 	// Plugin version
@@ -82,8 +142,160 @@ type Plugin struct {
 	Description string
 	// If true all metrics will be added to check message
 	AllMetricsInOutput bool
+	// If true messages of metrics within thresholds added with
+	// AllMetricsInOutput include the thresholds, e.g.
+	// "m1 is 123.456MB (warn 100, crit 150)"
+	ThresholdsInOutput bool
 	// Messages separator, default: ", "
 	MessageSeparator string
+	// Performance data entries separator, default: " "
+	PerfDataSeparator string
+	// If true OutputHash includes performance data
+	HashIncludesPerfData bool
+	// If true the host name is included in check output (as a prefix of
+	// the messages) and structured exports
+	IncludeHostname bool
+	// If true Assert adds its message as OK result also when the asserted
+	// condition holds
+	AssertAddsOKMessages bool
+	// If true the final status is derived from metrics statuses only,
+	// ignoring results and UpdateStatus calls
+	StatusFromMetricsOnly bool
+	// The worst status the check can have, e.g. WARNING during maintenance
+	// windows - applies to results, metrics and Exit helpers, default: UNKNOWN
+	MaxStatus Status
+	// Metrics with absolute value lower than this are not included in
+	// performance data (their status is still evaluated), default: 0
+	MinMetricValue float64
+	// If true a space is put between value and uom in check messages
+	// (performance data is not affected)
+	UOMSpaceInMessage bool
+	// Performance data min and max of metrics with the uom, default: "%"
+	// bounded to 0..100
+	UOMBounds map[string][2]float64
+	// Debug output writer, nil disables debug output
+	DebugOutput io.Writer
+	// Long names of flags which values are redacted in debug output
+	SensitiveFlags []string
+	// If true debug output is buffered and written after the result line,
+	// only if the final status is not OK (for check_by_ssh and similar
+	// transports combining stdout and stderr)
+	CombinedStreamSafe bool
+	// If true metrics with empty (or whitespace only) string value are
+	// recorded as undetermined (U) instead of being rejected
+	EmptyValueAsUnknown bool
+	// If true undetermined metrics raise the check status to UNKNOWN
+	EmptyValueEscalate bool
+	// If true thresholds are validated strictly, rejecting forms tolerated
+	// by the float parser: explicit plus sign ("+10"), exponent notation
+	// ("1e3"), special values ("inf", "NaN"), hexadecimal numbers ("0x1p4")
+	// and whitespace
+	StrictThresholdSyntax bool
+	// If true metric values passed as strings are compared with thresholds
+	// as exact decimal numbers instead of floats
+	ExactCompare bool
+	// Thresholds numbers may have unit suffixes which are expanded to the
+	// metric uom, e.g. "90GB" is 90000000000 for "B" uom (or metric without
+	// uom) and 90 for "GB" uom. Suffixes are rejected for metrics with other
+	// than size uom. IEC prefixes (Ki, Mi, Gi, ...) are powers of 1024, SI
+	// prefixes (k, M, G, ...) are powers of 1000, unless this is true, in
+	// which case they are powers of 1024 as well
+	BinaryUnitPrefixes bool
+	// Maximum number of sub-checks run concurrently by RunChecks, values
+	// lower than 2 run sub-checks sequentially
+	MaxConcurrentChecks int
+	// Aggregation of values of metric added multiple times, thresholds are
+	// checked against the aggregated value (but status is never lowered),
+	// default: AggregateNone (duplicated metrics are rejected)
+	MetricAggregation Aggregation
+	// If true a line with status (and breached threshold) of each metric is
+	// added to long output
+	ShowMetricStatuses bool
+	// If true the time elapsed since New is appended to check message,
+	// e.g. "(took 1.2s)"
+	ShowDurationInMessage bool
+	// Maximum number of results (added with AddResult) retained in check
+	// output, once exceeded the oldest OK results are dropped (other
+	// results are always retained), default: 0 (unlimited)
+	MaxResults int
+	// Message of the check recovered from panic in Final, "{name}" is
+	// replaced with plugin name and "{value}" with the panic value,
+	// default: "{name} panic: {value}"
+	PanicMessageFormat string
+	// Format of the check output summary line, "{status}" is replaced with
+	// the status label (see SetStatusLabels) and "{message}" with host name,
+	// results counts and messages, e.g. "[{status}] {message}", exit codes
+	// are not affected, default: "{status}: {message}"
+	SummaryFormat string
+	// If true WARNING final status is reported as CRITICAL by Final
+	// (applied after all other status adjustments, but within MaxStatus)
+	EscalateWarningToCritical bool
+	// If true the Exit helpers (ExitCritical etc.) keep metrics added so
+	// far in performance data, otherwise they are discarded
+	KeepMetricsOnExit bool
+	// If true messages containing "|" or new line are rejected, see
+	// AddMessageE
+	StrictMessages bool
+	// Maximum size of performance data in bytes, if exceeded Final outputs
+	// only the metrics (in order of names) which fit and escalates the check
+	// to WARNING, default: 0 (unlimited)
+	PerfDataByteBudget int
+	// Format of the check output printed by Final, name of registered
+	// formatter (see RegisterFormatter) - unknown formats are reported in
+	// debug output and Nagios output is printed, default: FormatNagios
+	OutputFormat Format
+	// If true AddMetric accepts any uom, otherwise only units permitted by
+	// Monitoring Plugins Development Guidelines are accepted, see
+	// permittedUOMs
+	AllowCustomUOM bool
+	// If true documentation URL of result added with AddResultDoc is
+	// appended to its message, e.g. "(see https://wiki/disk-full)"
+	DocumentationInOutput bool
+	// If true metrics with "c" (counter) uom are reported as per second
+	// rate since the previous run, samples are stored in "<Name>.counters"
+	// (or "<Name>-<StateKey>.counters") file in StateDir. No metric is
+	// added on the first run and when the counter decreased (it wrapped
+	// around or was reset)
+	CounterRates bool
+	// Directory of state files, default: os.TempDir(). A directory not
+	// writable by other users should be set on shared hosts, as anyone can
+	// replace the state files in the temporary directory
+	StateDir string
+	// Key of the check instance in state file names, e.g. the checked host
+	// name, so instances of the check do not share state, default: none
+	StateKey string
+	// Number of significant digits of float metric values in performance
+	// data, default: 0 (shortest representation which round trips). Float
+	// values are never formatted with exponent, integers are output as is
+	MetricPrecision int
+	// If true numbers of results (added with AddResult) by status are
+	// prepended to check messages, e.g. "CRITICAL: [2 CRITICAL, 5 OK] ..."
+	SummaryCounts bool
+	// If true Final orders messages from the worst status to the best (see
+	// AddResult), messages of the same status keep their order
+	SortMessagesBySeverity bool
+	// If true ParseArgs prints help message and exits with UNKNOWN status
+	// when -h/--help is given, otherwise ErrHelpRequested is returned (see
+	// HelpText), default: true
+	ExitOnHelp bool
+	// Prefix of metric names written by WriteMetrics in Graphite format,
+	// e.g. "nagios.web01.http"
+	MetricPrefix string
+	// Maximum size of check output in bytes, if exceeded long output lines
+	// are dropped, then the messages are trimmed and "..." appended and
+	// metrics are dropped (whole, from the end) if the messages cannot be
+	// trimmed enough - the status is always output intact, default: 0
+	// (unlimited)
+	MaxOutputLength int
+	// Maximum size of the first line of check output in bytes, if exceeded
+	// the messages are trimmed and "..." appended - the status and
+	// performance data are always output intact, default: 0 (unlimited)
+	MaxOutputBytes int
+	// Pattern of names of indexed metrics grouped into arrays in JSON
+	// export, with submatches of array name, index and field, e.g.
+	// `^(\w+?)_(\d+)_(\w+)$` groups "disk_0_usage" under "disk" array
+	// element 0 as "usage", see WriteJSON. Nil disables grouping
+	JSONArrayPattern *regexp.Regexp
 }
 
 type checkMetric struct {
@@ -92,244 +304,1478 @@ type checkMetric struct {
 	uom      string
 	warn     string
 	critical string
+	// performance data min and max, see UOMBounds
+	min, max string
+	// true for metrics computed from other metrics, e.g. group summaries
+	summary bool
+	// message added to check output for the metric
+	message string
+	// values of metric added multiple times, see MetricAggregation
+	samples []float64
+	// true for informational metrics, see AddInfoMetric
+	info bool
+	// true if value is an integer (as opposed to float)
+	integer bool
+	// breached threshold, e.g. "outside 10:20"
+	breach string
 }
 
 type checkMetrics map[string]*checkMetric
 
+const defaultPanicMessageFormat = "{name} panic: {value}"
+
+const defaultSummaryFormat = "{status}: {message}"
+
+var boundNames = [2]string{"min", "max"}
+
+// ErrHelpRequested is returned by ParseArgs if -h/--help is given and
+// ExitOnHelp is not set
+var ErrHelpRequested = errors.New("Help requested")
+
+var errInvalidMessage = errors.New("Invalid message, contains perfdata separator or new line")
+
+// noResult is result status of messages not added with AddResult
+const noResult Status = -1
+
 var pOsExit = func(code Status) { os.Exit(code.ExitCode()) }
 var pOutputHandle io.Writer = os.Stdout
 var pArgs = os.Args[1:]
+var pNow = time.Now
+var pHostname = os.Hostname
+
+/*
+New creates a new plugin instance.
+
+	check := plugin.New("check_service", "v1.0.0")
+
+*/
+func New(name, version string) *Plugin {
+	return &Plugin{
+		mu:                 &sync.Mutex{},
+		timeoutMu:          &sync.Mutex{},
+		debugBuf:           &bytes.Buffer{},
+		status:             OK,
+		messages:           make([]string, 0),
+		metrics:            make(checkMetrics),
+		bindings:           make(map[string]*thresholdBinding),
+		started:            pNow(),
+		Name:               name,
+		Version:            version,
+		AllMetricsInOutput: false,
+		MessageSeparator:   ", ",
+		PerfDataSeparator:  " ",
+		MaxStatus:          UNKNOWN,
+		PanicMessageFormat: defaultPanicMessageFormat,
+		SummaryFormat:      defaultSummaryFormat,
+		ExitOnHelp:         true,
+		UOMBounds: map[string][2]float64{
+			"%": {0, 100},
+		},
+	}
+}
+
+/*
+AddMetric adds new metric to check's performance data, with name and value
+parameters required. The optional string arguments include (in order):
+uom (unit of measurement), warning threshold, critical threshold, min and
+max - for details see Monitoring Plugins Development Guidelines.
+Note: Metrics names have to be unique.
+
+    // basic usage - add metric with value
+    check.AddMetric("load5", 0.98)
+
+    // metric with UOM
+    check.AddMetric("tmp", 15789, "MB")
+
+    // metric with warning threshold (without uom)
+    check.AddMetric("rtmax", 28.723, "", 75)
+
+    // metric with warning & critical thresholds (with uom)
+    check.AddMetric("rta", 24.558, "ms", 50, 100)
+
+    // metric with thresholds, min and max
+    check.AddMetric("used", 87.2, "GB", "80", "90", "0", "100")
+
+    // thresholds with unit suffixes, see BinaryUnitPrefixes
+    check.AddMetric("used", 86469132288, "B", "", "90GB")
+
+*/
+func (p *Plugin) AddMetric(name string, value interface{}, args ...string) error {
+	p.mu.Lock()
+	err := p.addMetricArgs(name, value, args...)
+	p.mu.Unlock()
+	return p.exitOnInvalidMessage(err)
+}
+
+// addMetricArgs adds metric with AddMetric arguments, p.mu must be held
+func (p *Plugin) addMetricArgs(name string, value interface{}, args ...string) error {
+	key := metricKey(name)
+	if b, ok := p.bindings[key]; ok && len(args) <= 1 {
+		args = b.args(args...)
+	}
+	argsCount := len(args)
+
+	name, err := NormalizeMetricName(key)
+	if err != nil {
+		return err
+	}
+	existing, duplicated := p.metrics[key]
+	if duplicated && (p.MetricAggregation == AggregateNone || existing.summary) {
+		return fmt.Errorf("Duplicated metric %s", name)
+	}
+
+	if argsCount >= 1 && !p.AllowCustomUOM && !permittedUOM(args[0]) {
+		return fmt.Errorf("Invalid UOM for %s: %s", name, args[0])
+	}
+
+	if s, ok := value.(string); ok && p.EmptyValueAsUnknown && len(strings.TrimSpace(s)) == 0 {
+		if duplicated {
+			// no data in this sample
+			return nil
+		}
+		return p.addUndeterminedMetric(key, args...)
+	}
+
+	var uom string
+	if argsCount >= 1 {
+		uom = args[0]
+	}
+
+	val, err := i2f(value)
+	if err != nil {
+		return fmt.Errorf("Invalid value of %s: %v", name, value)
+	}
+
+	var thresholds [2]*thresholdRange
+
+	if argsCount > 5 {
+		return fmt.Errorf("Too many arguments")
+	}
+	for i := 1; i < argsCount && i <= 2; i++ {
+		a := args[i]
+		if len(a) == 0 {
+			continue
+		}
+
+		r, err := p.parseThreshold(a, uom)
+		if err != nil {
+			return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdNames[i-1], name, a)
+		}
+		thresholds[i-1] = r
+	}
+	bounds, err := parseBounds(name, args)
+	if err != nil {
+		return err
+	}
+
+	if p.CounterRates && uom == "c" {
+		rate, ok, err := p.counterRate(key, val)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// no rate on the first run or when the counter wrapped around
+			return nil
+		}
+		value, val, uom = rate, rate, ""
+	}
+
+	samples := []float64{val}
+	if duplicated {
+		integer := existing.integer && isInteger(value) && p.MetricAggregation != AggregateAvg
+		samples = append(append(samples[:0:0], existing.samples...), val)
+		value, val = p.MetricAggregation.aggregate(samples, value)
+		if integer {
+			value = int64(val)
+		}
+	}
+
+	err = p.addMetric(key, value, val, uom, thresholds)
+	p.metrics[key].samples = samples
+	p.metrics[key].setBounds(bounds)
+	return err
+}
+
+// exitOnInvalidMessage exits the check with UNKNOWN status if err is
+// StrictMessages violation of message added along with metric (which is
+// added anyway), other errors are returned
+func (p *Plugin) exitOnInvalidMessage(err error) error {
+	if err == errInvalidMessage {
+		p.ExitUnknown("%s", err)
+		return nil // for testing only as it overrides the os.Exit
+	}
+	return err
+}
+
+// parseBounds validates min and max AddMetric arguments (the 4th and 5th)
+// of metric name, they are returned as given
+func parseBounds(name string, args []string) ([2]string, error) {
+	var bounds [2]string
+	for i := 3; i < len(args) && i <= 4; i++ {
+		if len(args[i]) == 0 {
+			continue
+		}
+		if _, err := strconv.ParseFloat(args[i], 64); err != nil {
+			return bounds, fmt.Errorf("Invalid format of %s %s: %s", boundNames[i-3], name, args[i])
+		}
+		bounds[i-3] = args[i]
+	}
+	return bounds, nil
+}
+
+// setBounds sets performance data min and max, overriding UOMBounds, empty
+// values are ignored
+func (m *checkMetric) setBounds(bounds [2]string) {
+	if len(bounds[0]) > 0 {
+		m.min = bounds[0]
+	}
+	if len(bounds[1]) > 0 {
+		m.max = bounds[1]
+	}
+}
+
+/*
+AddMetricCrit adds new metric with critical threshold only, it is a shortcut
+for AddMetric with empty warning threshold.
+
+    check.AddMetricCrit("used", 87.5, "%", "90")
+
+*/
+func (p *Plugin) AddMetricCrit(name string, value interface{}, uom, crit string) error {
+	return p.AddMetric(name, value, uom, "", crit)
+}
+
+/*
+AddInfoMetric adds informational metric, which never affects the check
+status - warning and critical thresholds are validated and output in
+performance data for reference only. Informational metrics are tagged as
+such in structured exports (see SortedMetrics).
+
+    check.AddInfoMetric("connections", 412, "500", "1000", "")
+
+*/
+func (p *Plugin) AddInfoMetric(name string, value interface{}, warn, crit, uom string) error {
+	key := metricKey(name)
+	var raw [2]string
+	for i, t := range []string{warn, crit} {
+		if len(t) == 0 {
+			continue
+		}
+		r, err := p.parseThreshold(t, uom)
+		if err != nil {
+			return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdNames[i], quoteLabel(key), t)
+		}
+		raw[i] = r.raw
+	}
+
+	if err := p.AddMetric(name, value, uom, "", ""); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	metric, ok := p.metrics[key]
+	if !ok {
+		// no counter rate yet, see CounterRates
+		return nil
+	}
+	metric.warn, metric.critical = raw[0], raw[1]
+	metric.info = true
+	return nil
+}
+
+// addMetric records already validated metric, checking its value against
+// warning and critical thresholds, p.mu must be held. Error is returned if
+// the metric message is rejected (see StrictMessages).
+func (p *Plugin) addMetric(key string, value interface{}, val float64, uom string, thresholds [2]*thresholdRange) error {
+	metric := &checkMetric{
+		value:   value,
+		uom:     uom,
+		integer: isInteger(value),
+	}
+	if bounds, ok := p.UOMBounds[uom]; ok {
+		metric.min = strconv.FormatFloat(bounds[0], 'f', -1, 64)
+		metric.max = strconv.FormatFloat(bounds[1], 'f', -1, 64)
+	}
+	name := quoteLabel(key)
+
+	var alertMessage string
+
+	msgUOM := metric.uom
+	if p.UOMSpaceInMessage && len(msgUOM) > 0 {
+		msgUOM = " " + msgUOM
+	}
+
+	for i, r := range thresholds {
+		if r == nil {
+			continue
+		}
+
+		switch i {
+		case 0:
+			metric.warn = r.raw
+		case 1:
+			metric.critical = r.raw
+		}
+
+		if p.thresholdBreached(r, val, value) {
+			metric.status = Status(i + 1) // i=0 warning, i=1 critical
+			if r.invert {
+				metric.breach = "inside " + r.raw
+			} else {
+				metric.breach = "outside " + r.raw
+			}
+			alertMessage = fmt.Sprintf("%s is %v%s (%s)", name, value, msgUOM, metric.breach)
+		}
+	}
+
+	if len(alertMessage) > 0 {
+		metric.message = alertMessage
+	} else if p.AllMetricsInOutput {
+		metric.message = fmt.Sprintf("%s is %v%s", name, value, msgUOM)
+		if p.ThresholdsInOutput {
+			var set []string
+			for i, r := range thresholds {
+				if r != nil {
+					set = append(set, thresholdShortNames[i]+" "+r.raw)
+				}
+			}
+			if len(set) > 0 {
+				metric.message += " (" + strings.Join(set, ", ") + ")"
+			}
+		}
+	}
+
+	var err error
+	if existing, ok := p.metrics[key]; ok && len(existing.message) > 0 {
+		err = p.replaceMessage(existing.message, metric.message)
+	} else if len(metric.message) > 0 {
+		err = p.addMessage(metric.message)
+	}
+
+	p.metrics[key] = metric
+	p.updateStatus(metric.status)
+	return err
+}
+
+// replaceMessage replaces the last occurrence of old message with new one,
+// removing it if new message is empty
+func (p *Plugin) replaceMessage(old, new string) error {
+	for i := len(p.messages) - 1; i >= 0; i-- {
+		if p.messages[i] != old {
+			continue
+		}
+		if len(new) > 0 {
+			p.messages[i] = new
+		} else {
+			p.removeMessage(i)
+		}
+		return nil
+	}
+	if len(new) > 0 {
+		return p.addMessage(new)
+	}
+	return nil
+}
+
+// addUndeterminedMetric records metric with undetermined (U) value, its
+// thresholds are validated as of other metrics, p.mu must be held
+func (p *Plugin) addUndeterminedMetric(key string, args ...string) error {
+	if len(args) > 5 {
+		return fmt.Errorf("Too many arguments")
+	}
+	var uom string
+	if len(args) >= 1 {
+		uom = args[0]
+	}
+	var raw [2]string
+	for i := 1; i < len(args) && i <= 2; i++ {
+		if len(args[i]) == 0 {
+			continue
+		}
+		r, err := p.parseThreshold(args[i], uom)
+		if err != nil {
+			return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdNames[i-1], quoteLabel(key), args[i])
+		}
+		raw[i-1] = r.raw
+	}
+	bounds, err := parseBounds(quoteLabel(key), args)
+	if err != nil {
+		return err
+	}
+
+	metric := &checkMetric{value: "U", warn: raw[0], critical: raw[1]}
+	metric.setBounds(bounds)
+
+	if p.AllMetricsInOutput {
+		metric.message = fmt.Sprintf("%s is undetermined", quoteLabel(key))
+		err = p.addMessage(metric.message)
+	}
+
+	if p.EmptyValueEscalate {
+		metric.status = UNKNOWN
+	}
+	p.metrics[key] = metric
+	p.updateStatus(metric.status)
+	return err
+}
+
+/*
+GetMetric returns value, uom and warning and critical thresholds of metric
+added with AddMetric, ok is false if there is no such metric. Name is
+normalized as in AddMetric, so both quoted and unquoted names can be used.
+
+    if value, uom, _, _, ok := check.GetMetric("used space"); ok {
+        check.AddLongMessage("used: %v%s", value, uom)
+    }
+
+*/
+func (p *Plugin) GetMetric(name string) (value interface{}, uom, warn, crit string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	metric, ok := p.metrics[metricKey(name)]
+	if !ok {
+		return nil, "", "", "", false
+	}
+	return metric.value, metric.uom, metric.warn, metric.critical, true
+}
+
+/*
+RemoveMetric removes metric (and its message, if any) added with AddMetric,
+it returns false if there is no such metric.
+Note: check status already raised by the metric is not rolled back, as it is
+aggregated with results and UpdateStatus calls. With StatusFromMetricsOnly
+the status is derived from the remaining metrics only.
+
+    check.AddMetric("replication_lag", lag, "s", "60", "300")
+    if !isReplica {
+        check.RemoveMetric("replication_lag")
+    }
+
+*/
+func (p *Plugin) RemoveMetric(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := metricKey(name)
+	metric, ok := p.metrics[key]
+	if !ok {
+		return false
+	}
+	if len(metric.message) > 0 {
+		p.replaceMessage(metric.message, "")
+	}
+	delete(p.metrics, key)
+	return true
+}
+
+/*
+AddMessage appends message to check output. If StrictMessages is set and the
+message contains "|" or new line, the check exits with UNKNOWN status.
+
+    check.AddMessage("Server %s", opts.Hostname)
+
+*/
+func (p *Plugin) AddMessage(format string, args ...interface{}) {
+	if err := p.AddMessageE(format, args...); err != nil {
+		p.ExitUnknown("%s", err)
+	}
+}
+
+/*
+AddMessageE appends message to check output like AddMessage, but if
+StrictMessages is set and the message contains "|" or new line, an error is
+returned (and the message is not added) instead of exiting.
+
+    if err := check.AddMessageE("Response: %s", body); err != nil {
+        ...
+    }
+
+*/
+func (p *Plugin) AddMessageE(format string, args ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.addMessage(formatMessage(format, args...))
+}
+
+// addMessage appends message to check output, checking it if StrictMessages
+// is set, p.mu must be held
+func (p *Plugin) addMessage(msg string) error {
+	if p.StrictMessages && strings.ContainsAny(msg, "|\n") {
+		p.debugf("Invalid message: %q", msg)
+		return errInvalidMessage
+	}
+	p.appendMessage(msg)
+	return nil
+}
+
+// appendMessage appends message to check output without any checks
+func (p *Plugin) appendMessage(msg string) {
+	p.messages = append(p.messages, msg)
+	p.resultStatuses = append(p.resultStatuses, noResult)
+}
+
+// formatMessage formats message, format is used as is if there are no args
+func formatMessage(format string, args ...interface{}) string {
+	if len(args) > 0 {
+		return fmt.Sprintf(format, args...)
+	}
+	return fmt.Sprint(format)
+}
+
+/*
+AddLongMessage appends line to check long output, printed by Final on
+separate lines after the summary line (with performance data).
+
+    for _, disk := range disks {
+        check.AddLongMessage("%s: %d%% used", disk.Path, disk.Usage)
+    }
+
+*/
+func (p *Plugin) AddLongMessage(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.longLines = append(p.longLines, formatMessage(format, args...))
+}
+
+/*
+AddFields appends "key: value" message for each of the fields, in sorted
+order of keys.
+
+    check.AddFields(map[string]interface{}{
+        "version": info.Version,
+        "uptime":  info.Uptime,
+    })
+
+*/
+func (p *Plugin) AddFields(fields map[string]interface{}) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p.AddMessage("%s: %v", k, fields[k])
+	}
+}
+
+/*
+AddResult aggregates results and appends message to check output - the worst
+result is final.
+
+    // would not change the final result
+    check.AddResult(plugin.OK, "Server %s", opts.Hostname)
+
+    // increases to WARNING level
+    if opts.SkipSSLChecks {
+        check.AddResult(plugin.WARNING, "Skiping SSL Certificate checks")
+    }
+
+*/
+func (p *Plugin) AddResult(code Status, format string, args ...interface{}) {
+	p.mu.Lock()
+	if code == OK {
+		p.okResults++
+	}
+	p.updateStatus(code)
+	err := p.addMessage(formatMessage(format, args...))
+	if err == nil {
+		p.resultStatuses[len(p.resultStatuses)-1] = code
+		p.evictResults()
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		p.ExitUnknown("%s", err)
+	}
+}
+
+/*
+AddResultDoc adds result like AddResult, with documentation URL (e.g.
+runbook with remediation steps) attached. Documentation URLs of all results
+are included in JSON export (see WriteJSON) and if DocumentationInOutput is
+set the URL is appended to the message as well. Only absolute http and https
+URLs are accepted, result is not added if the URL is invalid.
+
+    if usage > 90 {
+        check.AddResultDoc(plugin.CRITICAL, "https://wiki/disk-full", "Disk %s full", disk)
+    }
+
+*/
+func (p *Plugin) AddResultDoc(code Status, docURL, format string, args ...interface{}) error {
+	u, err := url.Parse(docURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("Invalid documentation URL: %s", docURL)
+	}
+
+	msg := formatMessage(format, args...)
+	if p.DocumentationInOutput {
+		msg += " (see " + docURL + ")"
+	}
+	p.AddResult(code, "%s", msg)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.documentation {
+		if d == docURL {
+			return nil
+		}
+	}
+	p.documentation = append(p.documentation, docURL)
+	return nil
+}
+
+// evictResults removes the oldest OK results while there are more than
+// MaxResults results, results with other statuses are retained
+func (p *Plugin) evictResults() {
+	if p.MaxResults <= 0 {
+		return
+	}
+
+	var count int
+	for _, st := range p.resultStatuses {
+		if st != noResult {
+			count++
+		}
+	}
+	for i := 0; i < len(p.resultStatuses) && count > p.MaxResults; {
+		if p.resultStatuses[i] == OK {
+			p.removeMessage(i)
+			count--
+			continue
+		}
+		i++
+	}
+}
+
+// removeMessage removes i-th message
+func (p *Plugin) removeMessage(i int) {
+	p.messages = append(p.messages[:i], p.messages[i+1:]...)
+	p.resultStatuses = append(p.resultStatuses[:i], p.resultStatuses[i+1:]...)
+}
+
+/*
+Assert adds result with failStatus and the message if ok is false. If ok is
+true and AssertAddsOKMessages is set, the message is added as OK result,
+otherwise nothing is added. The ok value is returned.
+
+    check.Assert(replicationRunning, plugin.CRITICAL, "Replication running: %v", replicationRunning)
+    if !check.Assert(lag < 60, plugin.WARNING, "Replication lag %ds", lag) {
+        ...
+    }
+
+*/
+func (p *Plugin) Assert(ok bool, failStatus Status, format string, args ...interface{}) bool {
+	switch {
+	case !ok:
+		p.AddResult(failStatus, format, args...)
+	case p.AssertAddsOKMessages:
+		p.AddResult(OK, format, args...)
+	}
+	return ok
+}
+
+/*
+RequireMinOK requires at least min OK results (added with AddResult) - if
+there are fewer when Final is called, the check becomes CRITICAL and the
+message is appended to check output.
+
+    // at least 3 of 5 replicas have to be healthy
+    check.RequireMinOK(3, "Less than 3 replicas healthy")
+    for _, r := range replicas {
+        check.AddResult(r.Status(), "replica %s", r.Name)
+    }
+
+*/
+func (p *Plugin) RequireMinOK(min int, format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.minOK = min
+	if len(args) > 0 {
+		p.minOKMessage = fmt.Sprintf(format, args...)
+	} else {
+		p.minOKMessage = format
+	}
+}
+
+/*
+StatusFromMessageCount sets warning and critical limits of the number of
+messages, evaluated in Final - the count is added as "matches" metric with
+the limits as thresholds, so WARNING is set if there are more than warn
+messages and CRITICAL if more than crit.
+
+    for _, line := range matchingLines {
+        check.AddMessage(line)
+    }
+    check.StatusFromMessageCount(5, 10)
+
+*/
+func (p *Plugin) StatusFromMessageCount(warn, crit int) {
+	p.messageCount = &[2]int{warn, crit}
+}
+
+/*
+Final calculates the final check output and exit status.
+
+    check := plugin.New("check_service, "v1.0.0")
+    // make sure Final() is called
+    defer check.Final()
+
+*/
+func (p *Plugin) Final() {
+	if r := recover(); r != nil {
+		p.ExitCritical("%s", p.panicMessage(r))
+		return // for testing only as it overrides the os.Exit
+	}
+	if err := p.validateBindings(); err != nil {
+		p.ExitUnknown("%s", err)
+		return // for testing only as it overrides the os.Exit
+	}
+	// results of cleanups are subject to the status policy
+	p.runCleanups()
+	p.prepare()
+	p.runSinks()
+	p.applyStatusPolicy()
+	p.saveMetricHistory()
+	p.final()
+}
+
+/*
+Render returns the check output and status as Final would print and exit
+with (the output ends with new line), without printing it and exiting. It
+allows to use the check result in a larger program, e.g. to merge results of
+several checks. The check itself is not changed - the final adjustments are
+applied to its copy, so metrics and results can still be added and Final
+called afterwards. Cleanup functions, sinks and OnFinal callbacks are not
+run.
+
+    output, status := check.Render()
+
+*/
+func (p *Plugin) Render() (output string, status Status) {
+	output, status = p.finalCopy().format()
+	return output + "\n", status
+}
+
+// finalCopy returns copy of the check with the final adjustments applied, as
+// they are in Final
+func (p *Plugin) finalCopy() *Plugin {
+	c := p.clone()
+	c.prepare()
+	c.applyStatusPolicy()
+	return c
+}
+
+// clone returns copy of the check with its own copy of status, messages and
+// metrics, used to render the output without changing the check
+func (p *Plugin) clone() *Plugin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := *p
+	c.mu = &sync.Mutex{}
+	c.messages = append([]string(nil), p.messages...)
+	c.resultStatuses = append([]Status(nil), p.resultStatuses...)
+	c.documentation = append([]string(nil), p.documentation...)
+	c.longLines = append([]string(nil), p.longLines...)
+	c.metrics = make(checkMetrics, len(p.metrics))
+	for name, metric := range p.metrics {
+		c.metrics[name] = metric
+	}
+	return &c
+}
+
+// prepare applies the final adjustments adding results and metrics - the
+// messages count, required OK results and performance data budget - only
+// once
+func (p *Plugin) prepare() {
+	p.mu.Lock()
+	done := p.prepared
+	p.prepared = true
+	p.mu.Unlock()
+	if done {
+		return
+	}
+
+	if p.messageCount != nil {
+		b := p.messageCount
+		p.mu.Lock()
+		count := len(p.messages)
+		p.mu.Unlock()
+		err := p.AddMetric("matches", count, "", strconv.Itoa(b[0]), strconv.Itoa(b[1]))
+		if err != nil {
+			p.debugf("Cannot add messages count: %s", err)
+		}
+	}
+	p.mu.Lock()
+	missingOK := p.okResults < p.minOK
+	p.mu.Unlock()
+	if missingOK {
+		p.AddResult(CRITICAL, p.minOKMessage)
+	}
+	p.applyPerfDataBudget()
+}
+
+// applyStatusPolicy applies StatusFromMetricsOnly, EscalateWarningToCritical
+// and SortMessagesBySeverity, after all results are added
+func (p *Plugin) applyStatusPolicy() {
+	p.mu.Lock()
+	if p.StatusFromMetricsOnly {
+		p.status = p.clampStatus(p.metricsStatus())
+	}
+	if p.EscalateWarningToCritical && p.status == WARNING {
+		p.status = p.clampStatus(CRITICAL)
+	}
+	p.mu.Unlock()
+	if p.SortMessagesBySeverity {
+		p.sortMessages()
+	}
+}
+
+// sortMessages orders messages from the worst status to the best, keeping
+// order of messages with the same status. Messages of results have status of
+// the result, alert messages of metrics status of the metric and other
+// messages are considered OK.
+func (p *Plugin) sortMessages() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	metricStatuses := make(map[string]Status)
+	for _, metric := range p.metrics {
+		if len(metric.message) > 0 && metric.status > metricStatuses[metric.message] {
+			metricStatuses[metric.message] = metric.status
+		}
+	}
+
+	severities := make([]Status, len(p.messages))
+	for i, msg := range p.messages {
+		if p.resultStatuses[i] != noResult {
+			severities[i] = p.resultStatuses[i]
+		} else {
+			severities[i] = metricStatuses[msg]
+		}
+	}
+
+	order := make([]int, len(p.messages))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return severities[order[a]] > severities[order[b]]
+	})
+
+	messages := make([]string, len(order))
+	statuses := make([]Status, len(order))
+	for i, j := range order {
+		messages[i], statuses[i] = p.messages[j], p.resultStatuses[j]
+	}
+	p.messages, p.resultStatuses = messages, statuses
+}
+
+// panicMessage returns check message for the panic value, see
+// PanicMessageFormat
+func (p *Plugin) panicMessage(value interface{}) string {
+	format := p.PanicMessageFormat
+	if len(format) == 0 {
+		format = defaultPanicMessageFormat
+	}
+	return strings.NewReplacer("{name}", p.Name, "{value}", fmt.Sprint(value)).Replace(format)
+}
+
+func (p *Plugin) final() {
+	p.timeoutMu.Lock()
+	if p.timedOut || p.finishing {
+		// the check is exiting on timeout or Exit* was called by a
+		// cleanup or OnFinal callback
+		p.timeoutMu.Unlock()
+		return
+	}
+	p.finishing = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.timeoutMu.Unlock()
+
+	p.finish()
+
+	// reached only if os.Exit is overridden (testing)
+	p.timeoutMu.Lock()
+	p.finishing = false
+	p.timeoutMu.Unlock()
+}
+
+// finish prints the check output and exits
+func (p *Plugin) finish() {
+	p.runCleanups()
+	output, status := p.format()
+	fmt.Fprintf(p.out(), "%s\n", output)
+	for _, fn := range p.onFinal {
+		fn(status, output)
+	}
+	p.flushDebug()
+	p.osExit(status)
+}
+
+// format returns the check output formatted with OutputFormat (without
+// trailing new line) and status
+func (p *Plugin) format() (string, Status) {
+	fn, ok := lookupFormatter(p.OutputFormat)
+	if !ok {
+		p.debugf("Unknown output format %s, using %s", p.OutputFormat, FormatNagios)
+	}
+
+	// formatters lock p.mu themselves, see RegisterFormatter
+	var b bytes.Buffer
+	if err := fn(p, &b); err != nil {
+		p.debugf("Cannot format %s output: %s", p.OutputFormat, err)
+	}
+	return strings.TrimRight(b.String(), "\n"), p.Status()
+}
+
+/*
+SetTimeout starts timer which exits the check with UNKNOWN status if Final
+(or one of the Exit helpers) is not called before the duration elapses.
+Calling it again restarts the timer with the new duration.
+
+    check := plugin.New("check_service", "v1.0.0")
+    check.SetTimeout(30 * time.Second)
+    defer check.Final()
+
+*/
+func (p *Plugin) SetTimeout(d time.Duration) {
+	p.timeoutMu.Lock()
+	defer p.timeoutMu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		p.timeoutMu.Lock()
+		if p.timer != timer || p.finishing {
+			// stopped or restarted meanwhile
+			p.timeoutMu.Unlock()
+			return
+		}
+		p.timer = nil
+		p.timedOut = true
+		p.finishing = true
+		p.timeoutMu.Unlock()
+		p.mu.Lock()
+		p.status = p.clampStatus(UNKNOWN)
+		p.setExitMessage(fmt.Sprintf("%s timed out after %s", p.Name, d))
+		if !p.KeepMetricsOnExit {
+			p.metrics = make(checkMetrics)
+		}
+		p.mu.Unlock()
+		p.finish()
+	})
+	p.timer = timer
+}
+
+// expired returns true if the check timed out, see SetTimeout
+func (p *Plugin) expired() bool {
+	p.timeoutMu.Lock()
+	defer p.timeoutMu.Unlock()
+	return p.timedOut
+}
 
 /*
-New creates a new plugin instance.
+Run runs the check function fn with ctx and exits - with CRITICAL status and
+the error as message if fn returns an error, with UNKNOWN status if ctx is
+cancelled or its deadline is exceeded before fn returns and via Final
+otherwise. Panics in fn are handled as in Final. Note: fn should return
+promptly once ctx is done, it is not waited for.
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    check.Run(ctx, func(ctx context.Context) error {
+        return checkService(ctx, check, opts.Hostname)
+    })
 
-	check := plugin.New("check_service", "v1.0.0")
+*/
+func (p *Plugin) Run(ctx context.Context, fn func(context.Context) error) {
+	type result struct {
+		err       error
+		panicked  bool
+		recovered interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		panicked := true
+		defer func() {
+			if panicked {
+				done <- result{panicked: true, recovered: recover()}
+			}
+		}()
+		err := fn(ctx)
+		panicked = false
+		done <- result{err: err}
+	}()
+
+	select {
+	case r := <-done:
+		switch {
+		case r.panicked:
+			p.ExitCritical("%s", p.panicMessage(r.recovered))
+		case r.err != nil && ctx.Err() != nil:
+			p.exitOnContext(ctx.Err())
+		case r.err != nil:
+			p.ExitCritical("%s", r.err)
+		default:
+			p.Final()
+		}
+	case <-ctx.Done():
+		p.exitOnContext(ctx.Err())
+	}
+}
+
+// exitOnContext exits with UNKNOWN status for the ctx error
+func (p *Plugin) exitOnContext(err error) {
+	if err == context.DeadlineExceeded {
+		p.ExitUnknown("%s timed out", p.Name)
+		return // for testing only as it overrides the os.Exit
+	}
+	p.ExitUnknown("%s cancelled: %s", p.Name, err)
+}
+
+/*
+OnFinal registers callback which is called with the final status and check
+output (without trailing new line) right before the check exits, both from
+Final (including the panic path) and the Exit helpers. Callbacks are called
+in order of registration, after cleanup functions were run and the output
+was printed.
+
+    check.OnFinal(func(status plugin.Status, output string) {
+        if status == plugin.CRITICAL {
+            criticalRuns.Inc()
+        }
+    })
 
 */
-func New(name, version string) *Plugin {
-	return &Plugin{
-		status:             OK,
-		messages:           make([]string, 0),
-		metrics:            make(checkMetrics),
-		Name:               name,
-		Version:            version,
-		AllMetricsInOutput: false,
-		MessageSeparator:   ", ",
+func (p *Plugin) OnFinal(fn func(status Status, output string)) {
+	p.onFinal = append(p.onFinal, fn)
+}
+
+/*
+AddSink registers sink which Final calls with the check to emit its result,
+e.g. to a file, socket or Pushgateway. Sinks are called in order of
+registration, before the output is printed. If a sink returns an error the
+check status is raised to at least WARNING and the error is reported in check
+output, the remaining sinks are still called.
+
+    check.AddSink(func(check *plugin.Plugin) error {
+        return check.WriteToSocket("/run/metrics.sock")
+    })
+
+*/
+func (p *Plugin) AddSink(fn func(*Plugin) error) {
+	p.sinks = append(p.sinks, fn)
+}
+
+// runSinks calls all sinks, reporting their errors as WARNING results
+func (p *Plugin) runSinks() {
+	var errs []error
+	for _, fn := range p.sinks {
+		if err := fn(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, err := range errs {
+		p.AddResult(WARNING, "sink failed: %s", err)
 	}
 }
 
 /*
-AddMetric adds new metric to check's performance data, with name and value
-parameters required. The optional string arguments include (in order):
-uom (unit of measurement), warning threshold, critical threshold - for
-details see Monitoring Plugins Development Guidelines.
-Note: Metrics names have to be unique.
+WithOutput runs fn with check output redirected to w, the previous output is
+restored afterwards (also if fn panics).
 
-    // basic usage - add metric with value
-    check.AddMetric("load5", 0.98)
+    var b bytes.Buffer
+    check.WithOutput(&b, func() {
+        check.Final()
+    })
 
-    // metric with UOM
-    check.AddMetric("tmp", 15789, "MB")
+*/
+func (p *Plugin) WithOutput(w io.Writer, fn func()) {
+	previous := p.output
+	p.output = w
+	defer func() { p.output = previous }()
+	fn()
+}
 
-    // metric with warning threshold (without uom)
-    check.AddMetric("rtmax", 28.723, "", 75)
+/*
+SetOutput sets writer the check output (and help) is written to, instead of
+standard output.
 
-    // metric with warning & critical thresholds (with uom)
-    check.AddMetric("rta", 24.558, "ms", 50, 100)
+    var b bytes.Buffer
+    check.SetOutput(&b)
 
 */
-func (p *Plugin) AddMetric(name string, value interface{}, args ...string) error {
-	argsCount := len(args)
+func (p *Plugin) SetOutput(w io.Writer) {
+	p.output = w
+}
+
+/*
+SetExitFunc sets function called with the final status when the check
+exits, instead of os.Exit. It allows to run the check embedded in other
+process and to test it.
+
+    var status plugin.Status
+    check.SetExitFunc(func(st plugin.Status) { status = st })
+
+*/
+func (p *Plugin) SetExitFunc(fn func(Status)) {
+	p.exitFunc = fn
+}
 
-	metric := &checkMetric{}
+// out returns writer the check output is written to
+func (p *Plugin) out() io.Writer {
+	if p.output != nil {
+		return p.output
+	}
+	return pOutputHandle
+}
 
-	if strings.ContainsRune(name, ' ') && !strings.HasPrefix(name, "'") {
-		name = "'" + name + "'"
+// osExit exits with status, see SetExitFunc
+func (p *Plugin) osExit(code Status) {
+	if p.exitFunc != nil {
+		p.exitFunc(code)
+		return
 	}
-	if _, ok := p.metrics[name]; ok {
-		return fmt.Errorf("Duplicated metric %s", name)
+	pOsExit(code)
+}
+
+/*
+Capture returns the status and check output as Render, without trailing new
+line. Unlike Final it does not print anything, run cleanup functions nor
+exit.
+
+    status, output := check.Capture()
+    log.Printf("embedded check result %d: %s", status, output)
+
+*/
+func (p *Plugin) Capture() (Status, string) {
+	output, status := p.Render()
+	return status, strings.TrimSuffix(output, "\n")
+}
+
+/*
+StatusLine returns compact single line status for health endpoints - the
+summary line of the output Final would print (see SummaryFormat), without
+performance data nor trailing new line, e.g. "OK" or "CRITICAL: Connection
+refused". Like Capture it does not print anything nor exit.
+
+    http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, check.StatusLine())
+    })
+
+*/
+func (p *Plugin) StatusLine() string {
+	c := p.finalCopy()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary := c.summary()
+	if len(summary.body) == 0 {
+		// "OK" rather than "OK:"
+		summary.prefix = strings.TrimSuffix(summary.prefix, ":")
 	}
+	return summary.String()
+}
 
-	metric.value = value
-	if argsCount >= 1 {
-		metric.uom = args[0]
+// render writes the check output (without trailing new line) to w
+func (p *Plugin) render(w io.Writer) {
+	summary := p.summary()
+	if p.ShowDurationInMessage {
+		summary.body += fmt.Sprintf(" (took %.1fs)", pNow().Sub(p.started).Seconds())
 	}
 
-	val, err := i2f(value)
-	if err != nil {
-		return fmt.Errorf("Invalid value of %s: %v", name, value)
+	tokens, long := p.perfDataTokens(), p.longOutput()
+	if p.MaxOutputLength > 0 && len(p.joinOutput(summary, tokens, long)) > p.MaxOutputLength {
+		summary, tokens, long = p.fitOutput(summary, tokens, long)
 	}
+	fmt.Fprint(w, p.joinOutput(summary, tokens, long))
+}
 
-	var alertMessage string
+// joinOutput returns check output of summary line, performance data tokens
+// and long output lines, with summary truncated to MaxOutputBytes
+func (p *Plugin) joinOutput(summary summaryLine, tokens, long []string) string {
+	// performance data put on separate lines has to follow the long
+	// output (if any) after "|", otherwise it is taken as long output
+	var perfData string
+	var trailing []string
+	if len(tokens) > 0 {
+		if strings.ContainsRune(p.PerfDataSeparator, '\n') {
+			tokens, trailing = tokens[:1], tokens[1:]
+		}
+		perfData = " | " + strings.Join(tokens, p.PerfDataSeparator)
+	}
 
-	if argsCount == 2 || argsCount == 3 {
-		var thresholdBreached bool
-		for i, a := range args[1:] {
-			var thresholdName string
-			var invert bool
+	var b strings.Builder
+	if p.MaxOutputBytes > 0 {
+		summary = summary.truncate(p.MaxOutputBytes - len(perfData))
+	}
+	b.WriteString(summary.String())
+	b.WriteString(perfData)
+	if len(long) > 0 {
+		b.WriteString("\n")
+		b.WriteString(strings.Join(long, "\n"))
+	}
+	if len(trailing) > 0 {
+		if len(long) > 0 {
+			b.WriteString(" | ")
+		} else {
+			b.WriteString("\n| ")
+		}
+		b.WriteString(strings.Join(trailing, p.PerfDataSeparator))
+	}
+	return b.String()
+}
 
-			if len(a) == 0 {
-				continue
-			}
+// fitOutput shortens check output to MaxOutputLength - long output lines are
+// dropped from the end, then performance data tokens are dropped from the end
+// while they do not fit even with the messages trimmed and finally the
+// messages are trimmed to fit. The status prefix is always retained, so the
+// output may still exceed the limit.
+func (p *Plugin) fitOutput(summary summaryLine, tokens, long []string) (summaryLine, []string, []string) {
+	for len(long) > 0 && len(p.joinOutput(summary, tokens, long)) > p.MaxOutputLength {
+		long = long[:len(long)-1]
+	}
+	minimal := summary.truncate(0)
+	for len(tokens) > 0 && len(p.joinOutput(minimal, tokens, long)) > p.MaxOutputLength {
+		tokens = tokens[:len(tokens)-1]
+	}
 
-			arg := strings.TrimPrefix(a, "@")
-			if a != arg {
-				invert = true
-			}
+	reserved := len(p.joinOutput(minimal, tokens, long)) - len(minimal.String())
+	return summary.truncate(p.MaxOutputLength - reserved), tokens, long
+}
 
-			thresh := strings.Split(arg, ":")
+// summaryLine is the check output summary line split into status (host name
+// and results counts) prefix, messages body (starting with separator) and
+// suffix, as formatted with SummaryFormat
+type summaryLine struct {
+	prefix, body, suffix string
+}
 
-			switch i {
-			case 0:
-				thresholdName = "warning"
-				metric.warn = a
-			case 1:
-				thresholdName = "critical"
-				metric.critical = a
-			}
+func (s summaryLine) String() string {
+	return strings.TrimSpace(s.prefix + s.body + s.suffix)
+}
 
-			switch len(thresh) {
-			case 1:
-				// v < X
-				tMax, err := strconv.ParseFloat(thresh[0], 64)
-				if err != nil {
-					return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-				}
-				thresholdBreached = val < 0 || val > tMax
-			case 2:
-				switch {
-				case thresh[0] == "~":
-					tMax, err := strconv.ParseFloat(thresh[1], 64)
-					if err != nil {
-						return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-					}
-					thresholdBreached = val > tMax
-				case thresh[1] == "":
-					tMin, err := strconv.ParseFloat(thresh[0], 64)
-					if err != nil {
-						return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-					}
-					thresholdBreached = val < tMin
-				default:
-					tMin, err := strconv.ParseFloat(thresh[0], 64)
-					if err != nil {
-						return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-					}
-					tMax, err := strconv.ParseFloat(thresh[1], 64)
-					if err != nil {
-						return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-					}
-					if tMin > tMax {
-						return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-					}
-					thresholdBreached = val < tMin || val > tMax
-				}
-			default:
-				return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdName, name, a)
-			}
+// truncate trims messages of the summary line with ellipsis to fit limit
+// bytes, the prefix and suffix are always retained
+func (s summaryLine) truncate(limit int) summaryLine {
+	const ellipsis = "..."
+	if len(s.body) == 0 || len(s.String()) <= limit {
+		return s
+	}
 
-			if invert {
-				thresholdBreached = !thresholdBreached
-			}
+	keep := limit - len(s.prefix) - len(s.suffix) - len(ellipsis)
+	if keep < 1 {
+		s.body = ellipsis
+		if len(s.prefix) > 0 {
+			s.body = " " + ellipsis
+		}
+		return s
+	}
+	for keep > 0 && !utf8.RuneStart(s.body[keep]) {
+		keep--
+	}
+	s.body = s.body[:keep] + ellipsis
+	return s
+}
 
-			if thresholdBreached {
-				metric.status = Status(i + 1) // i=0 warning, i=1 critical
-				if invert {
-					alertMessage = fmt.Sprintf("%s is %v%s (inside %s)", name, value, metric.uom, a)
-				} else {
-					alertMessage = fmt.Sprintf("%s is %v%s (outside %s)", name, value, metric.uom, a)
-				}
+// longOutput returns lines of long (multi-line) check output
+func (p *Plugin) longOutput() []string {
+	lines := append([]string(nil), p.longLines...)
+	if p.ShowMetricStatuses {
+		for _, name := range p.sortedMetricNames() {
+			metric := p.metrics[name]
+			line := fmt.Sprintf("%s: %s", quoteLabel(name), p.statusLabel(metric.status))
+			if len(metric.breach) > 0 {
+				line += " (" + metric.breach + ")"
 			}
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
 
+// summary returns the check output summary line formatted with
+// SummaryFormat
+func (p *Plugin) summary() summaryLine {
+	format := p.SummaryFormat
+	if len(format) == 0 {
+		format = defaultSummaryFormat
+	}
+	if !strings.Contains(format, "{message}") {
+		format += " {message}"
+	}
+	status := strings.NewReplacer("{status}", p.statusLabel(p.status))
+	i := strings.Index(format, "{message}")
+	lead := status.Replace(format[:i])
+	suffix := status.Replace(format[i+len("{message}"):])
+
+	// host name and results counts follow the status, separated by space
+	// as are messages then
+	prefix := strings.TrimRight(lead, " ")
+	sep := lead[len(prefix):]
+	parts := []string{prefix}
+	if hostname := p.hostname(); len(hostname) > 0 {
+		parts = append(parts, "["+hostname+"]")
+	}
+	if p.SummaryCounts {
+		if counts := p.resultCounts(); len(counts) > 0 {
+			parts = append(parts, "["+counts+"]")
 		}
-	} else if argsCount > 3 {
-		return fmt.Errorf("Too many arguments")
 	}
+	if len(parts) > 1 {
+		sep = " "
+	}
+	prefix = strings.TrimLeft(strings.Join(parts, " "), " ")
 
-	if len(alertMessage) > 0 {
-		p.AddMessage(alertMessage)
-	} else if p.AllMetricsInOutput {
-		p.AddMessage(fmt.Sprintf("%s is %v%s", name, value, metric.uom))
+	var body string
+	if len(p.messages) > 0 {
+		body = strings.Join(p.messages, p.MessageSeparator)
+		if len(prefix) > 0 {
+			body = sep + body
+		}
 	}
+	return summaryLine{prefix, body, suffix}
+}
 
-	p.metrics[name] = metric
-	p.UpdateStatus(metric.status)
-	return nil
+// resultCounts returns numbers of results by status, from the worst, e.g.
+// "2 CRITICAL, 1 WARNING, 5 OK"
+func (p *Plugin) resultCounts() string {
+	var counts [UNKNOWN + 1]int
+	for _, st := range p.resultStatuses {
+		if st >= OK && st <= UNKNOWN {
+			counts[st]++
+		}
+	}
+
+	var parts []string
+	for _, st := range []Status{UNKNOWN, CRITICAL, WARNING, OK} {
+		if counts[st] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[st], p.statusLabel(st)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// renderSummary writes the status and messages to w
+func (p *Plugin) renderSummary(w io.Writer) {
+	fmt.Fprint(w, p.summary())
 }
 
 /*
-AddMessage appends message to check output.
+SetStatusLabels sets labels used for statuses in check output, e.g. to
+localize it. Statuses without label are output as usual, exit codes are not
+affected. Machine readable exports (e.g. WriteJSON) use the usual names.
 
-    check.AddMessage("Server %s", opts.Hostname)
+    check.SetStatusLabels(map[plugin.Status]string{
+        plugin.WARNING:  "WARNUNG",
+        plugin.CRITICAL: "KRITISCH",
+        plugin.UNKNOWN:  "UNBEKANNT",
+    })
 
 */
-func (p *Plugin) AddMessage(format string, args ...interface{}) {
-	var msg string
-	if len(args) > 0 {
-		msg = fmt.Sprintf(format, args...)
-	} else {
-		msg = fmt.Sprint(format)
+func (p *Plugin) SetStatusLabels(labels map[Status]string) error {
+	for st, label := range labels {
+		if st < OK || st > UNKNOWN {
+			return fmt.Errorf("Invalid status: %d", st)
+		}
+		if len(label) == 0 {
+			return fmt.Errorf("Invalid label of %s status", st)
+		}
 	}
-	p.messages = append(p.messages, msg)
+
+	p.statusLabels = make(map[Status]string, len(labels))
+	for st, label := range labels {
+		p.statusLabels[st] = label
+	}
+	return nil
 }
 
-/*
-AddResult aggregates results and appends message to check output - the worst
-result is final.
+// statusLabel returns label of status used in check output
+func (p *Plugin) statusLabel(st Status) string {
+	if label, ok := p.statusLabels[st]; ok {
+		return label
+	}
+	return st.String()
+}
 
-    // would not change the final result
-    check.AddResult(plugin.OK, "Server %s", opts.Hostname)
+// metricsStatus returns the worst status of all metrics
+func (p *Plugin) metricsStatus() Status {
+	status := OK
+	for _, metric := range p.metrics {
+		if metric.status > status {
+			status = metric.status
+		}
+	}
+	return status
+}
 
-    // increases to WARNING level
-    if opts.SkipSSLChecks {
-        check.AddResult(plugin.WARNING, "Skiping SSL Certificate checks")
+// hostname returns the host name if IncludeHostname is enabled, empty string
+// otherwise or if it cannot be determined
+func (p *Plugin) hostname() string {
+	if !p.IncludeHostname {
+		return ""
+	}
+	hostname, err := pHostname()
+	if err != nil {
+		p.debugf("Cannot determine hostname: %s", err)
+		return ""
+	}
+	return hostname
+}
+
+func (p *Plugin) sortedMetricNames() []string {
+	sorted := make([]string, 0, len(p.metrics))
+	for k := range p.metrics {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+/*
+Defer registers cleanup function which is run before the check exits, both
+from Final (including the panic path) and the Exit helpers. Cleanup
+functions are run in LIFO order, in Final before the status policy (e.g.
+EscalateWarningToCritical) is applied, so it covers their results too.
+
+    tmp, err := ioutil.TempFile("", "check_service")
+    if err != nil {
+        check.ExitUnknown("Cannot create temporary file: %s", err)
     }
+    check.Defer(func() { os.Remove(tmp.Name()) })
 
 */
-func (p *Plugin) AddResult(code Status, format string, args ...interface{}) {
-	p.UpdateStatus(code)
-	p.AddMessage(format, args...)
+func (p *Plugin) Defer(fn func()) {
+	p.cleanups = append(p.cleanups, fn)
 }
 
 /*
-Final calculates the final check output and exit status.
+DeferE registers cleanup function like Defer, but if it returns an error
+the check status is raised to at least WARNING and the error is reported in
+check output.
 
-    check := plugin.New("check_service, "v1.0.0")
-    // make sure Final() is called
-    defer check.Final()
+    check.DeferE(conn.Close)
 
 */
-func (p *Plugin) Final() {
-	if r := recover(); r != nil {
-		p.ExitCritical("%s panic: %v", p.Name, r)
-		return // for testing only as it overrides the os.Exit
-	}
-	fmt.Fprintf(pOutputHandle, "%s:", p.status.String())
-	if len(p.messages) > 0 {
-		fmt.Fprintf(pOutputHandle, " ")
-		fmt.Fprint(pOutputHandle, strings.Join(p.messages, p.MessageSeparator))
-	}
-	if len(p.metrics) > 0 {
-		var sorted []string
-		sorted = make([]string, 0, len(p.metrics))
-
-		fmt.Fprintf(pOutputHandle, " |")
-		for k := range p.metrics {
-			sorted = append(sorted, k)
-		}
-		sort.Strings(sorted)
-		for _, k := range sorted {
-			fmt.Fprintf(pOutputHandle, " %s=%v%s;%s;%s;;",
-				k,
-				p.metrics[k].value,
-				p.metrics[k].uom,
-				p.metrics[k].warn,
-				p.metrics[k].critical,
-			)
+func (p *Plugin) DeferE(fn func() error) {
+	p.Defer(func() {
+		if err := fn(); err != nil {
+			p.AddResult(WARNING, "cleanup failed: %s", err)
 		}
+	})
+}
+
+func (p *Plugin) runCleanups() {
+	for len(p.cleanups) > 0 {
+		fn := p.cleanups[len(p.cleanups)-1]
+		p.cleanups = p.cleanups[:len(p.cleanups)-1]
+		fn()
 	}
-	fmt.Fprintf(pOutputHandle, "\n")
-	pOsExit(p.status)
 }
 
 /*
@@ -339,20 +1785,45 @@ SetMessage replaces accumulated messages with new one provided.
 
 */
 func (p *Plugin) SetMessage(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.setMessage(formatMessage(format, args...))
+}
+
+// setMessage replaces messages with msg, p.mu must be held
+func (p *Plugin) setMessage(msg string) {
 	p.messages = []string{}
-	p.AddMessage(format, args...)
+	p.resultStatuses = nil
+	p.appendMessage(msg)
 }
 
 func (p *Plugin) exit(code Status, format string, args ...interface{}) {
-	p.status = code
-	p.SetMessage(format, args...)
-	p.metrics = make(checkMetrics)
-	p.Final()
+	p.exitKeepingMetrics(code, p.KeepMetricsOnExit, format, args...)
+}
+
+// setExitMessage replaces messages and long output with msg, p.mu must be
+// held
+func (p *Plugin) setExitMessage(msg string) {
+	p.setMessage(msg)
+	p.longLines = nil
+	p.documentation = nil
+}
+
+func (p *Plugin) exitKeepingMetrics(code Status, keep bool, format string, args ...interface{}) {
+	p.mu.Lock()
+	p.status = p.clampStatus(code)
+	p.setExitMessage(formatMessage(format, args...))
+	if !keep {
+		p.metrics = make(checkMetrics)
+	}
+	p.mu.Unlock()
+	p.final()
 }
 
 /*
 ExitOK exits with specified message and OK exit status.
-Note: existing messages and metrics are discarded.
+Note: existing messages, long output and metrics (unless KeepMetricsOnExit)
+are discarded.
 
     check.ExitOK("Test mode | metric1=1.1; metric2=2.2")
 
@@ -362,28 +1833,76 @@ func (p *Plugin) ExitOK(format string, args ...interface{}) {
 }
 
 // ExitUnknown exits with specified message and UNKNOWN exit status.
-// Note: existing messages and metrics are discarded.
+// Note: existing messages, long output and metrics (unless KeepMetricsOnExit)
+// are discarded.
 func (p *Plugin) ExitUnknown(format string, args ...interface{}) {
 	p.exit(UNKNOWN, format, args...)
 }
 
 // ExitWarning exits with specified message and WARNING exit status.
-// Note: existing messages and metrics are discarded.
+// Note: existing messages, long output and metrics (unless KeepMetricsOnExit)
+// are discarded.
 func (p *Plugin) ExitWarning(format string, args ...interface{}) {
 	p.exit(WARNING, format, args...)
 }
 
 // ExitCritical exits with specified message and CRITICAL exit status.
-// Note: existing messages and metrics are discarded.
+// Note: existing messages, long output and metrics (unless KeepMetricsOnExit)
+// are discarded.
 func (p *Plugin) ExitCritical(format string, args ...interface{}) {
 	p.exit(CRITICAL, format, args...)
 }
 
+/*
+ExitOKWithMetrics exits with specified message and OK exit status, keeping
+the metrics added so far in performance data output regardless of
+KeepMetricsOnExit.
+Note: existing messages and long output are discarded.
+
+    check.AddMetric("rta", 24.558, "ms")
+    check.ExitOKWithMetrics("Service in maintenance")
+
+*/
+func (p *Plugin) ExitOKWithMetrics(format string, args ...interface{}) {
+	p.exitKeepingMetrics(OK, true, format, args...)
+}
+
+// ExitUnknownWithMetrics exits with specified message and UNKNOWN exit
+// status, keeping the metrics added so far.
+// Note: existing messages and long output are discarded.
+func (p *Plugin) ExitUnknownWithMetrics(format string, args ...interface{}) {
+	p.exitKeepingMetrics(UNKNOWN, true, format, args...)
+}
+
+// ExitWarningWithMetrics exits with specified message and WARNING exit
+// status, keeping the metrics added so far.
+// Note: existing messages and long output are discarded.
+func (p *Plugin) ExitWarningWithMetrics(format string, args ...interface{}) {
+	p.exitKeepingMetrics(WARNING, true, format, args...)
+}
+
+// ExitCriticalWithMetrics exits with specified message and CRITICAL exit
+// status, keeping the metrics added so far.
+// Note: existing messages and long output are discarded.
+func (p *Plugin) ExitCriticalWithMetrics(format string, args ...interface{}) {
+	p.exitKeepingMetrics(CRITICAL, true, format, args...)
+}
+
 /*
 ParseArgs parses the command line options using flags parsing library
 providing handling of short/long names, flags and lists, and default and
 required options. For details please see https://godoc.org/github.com/jessevdk/go-flags.
-Note: -h/--help is automatically added
+Note: -h/--help is automatically added, as well as -V/--version (printing
+plugin name and version) and repeatable -v/--verbose unless opts already
+define them. See ExitOnHelp for handling of -h/--help without exiting.
+
+The -v flag is counted (also when defined by opts as bool slice), combined
+short flags are supported, so -vvv is the same as -v -v -v. The number of
+occurrences is available via Verbosity.
+
+	var opts struct {
+		Hostname string `short:"H" long:"hostname"`
+	}
 
 	if err := check.ParseArgs(&opts); err != nil {
 		check.ExitCritical("Error parsing arguments: %s", err)
@@ -393,36 +1912,130 @@ Note: -h/--help is automatically added
 func (p *Plugin) ParseArgs(opts interface{}) error {
 	var err error
 
-	var builtin struct {
-		Help bool `short:"h" long:"help" description:"Show this help message"`
+	parser, builtin := p.newParser(opts)
+	_, err = parser.ParseArgs(pArgs)
+
+	p.verbosity = len(builtin.Verbose)
+	if o := parser.FindOptionByShortName('v'); o != nil {
+		if v, ok := o.Value().([]bool); ok {
+			p.verbosity = len(v)
+		}
+	}
+
+	if builtin.Version && !builtin.Help {
+		fmt.Fprintf(p.out(), "%s v%s\n", p.Name, strings.TrimPrefix(p.Version, "v"))
+		p.osExit(UNKNOWN)
+	}
+
+	if builtin.Help {
+		if !p.ExitOnHelp {
+			return ErrHelpRequested
+		}
+		fmt.Fprint(p.out(), p.helpText(parser))
+		p.osExit(UNKNOWN)
 	}
+
+	return err
+}
+
+// builtinOptions are options added by ParseArgs to plugin options
+type builtinOptions struct {
+	Help    bool   `short:"h" long:"help" description:"Show this help message"`
+	Version bool   `short:"V" long:"version" description:"Show plugin version"`
+	Verbose []bool `short:"v" long:"verbose" description:"Verbose output, repeat for more details"`
+}
+
+// newParser returns parser of opts with builtin options added, other than
+// -h/--help only if not defined by opts
+func (p *Plugin) newParser(opts interface{}) (*flags.Parser, *builtinOptions) {
+	builtin := &builtinOptions{}
 	parser := flags.NewParser(opts, 0)
-	_, err = parser.AddGroup("Default Options", "", &builtin)
+
+	// the group of builtin options is built with pointers to fields of
+	// builtin which are not defined by opts
+	v := reflect.ValueOf(builtin).Elem()
+	var fields []reflect.StructField
+	var values []reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		short, _ := utf8.DecodeRuneInString(field.Tag.Get("short"))
+		defined := parser.FindOptionByShortName(short) != nil ||
+			parser.FindOptionByLongName(field.Tag.Get("long")) != nil
+		if defined && field.Name != "Help" {
+			continue
+		}
+		field.Type = reflect.PtrTo(field.Type)
+		fields = append(fields, field)
+		values = append(values, v.Field(i).Addr())
+	}
+	group := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, value := range values {
+		group.Field(i).Set(value)
+	}
+	parser.AddGroup("Default Options", "", group.Addr().Interface())
 
 	g := parser.Command.Group.Find("Application Options")
 	if g != nil {
 		g.ShortDescription = "Plugin Options"
 	}
+	return parser, builtin
+}
 
-	_, err = parser.ParseArgs(pArgs)
+/*
+HelpText returns help message printed by ParseArgs for -h/--help - plugin
+name and version, Preamble, usage of opts and builtin options and
+Description.
 
-	if builtin.Help {
-		fmt.Fprintf(pOutputHandle, "%s v%s\n", p.Name, strings.TrimPrefix(p.Version, "v"))
-		if len(p.Preamble) > 0 {
-			fmt.Fprintln(pOutputHandle, p.Preamble)
-		}
-		parser.Options = flags.HelpFlag
-		var b bytes.Buffer
-		parser.WriteHelp(&b)
-		fmt.Fprintln(pOutputHandle, b.String())
+    fmt.Print(check.HelpText(&opts))
 
-		if len(p.Description) > 0 {
-			fmt.Fprintln(pOutputHandle, p.Description)
-		}
-		pOsExit(UNKNOWN)
+*/
+func (p *Plugin) HelpText(opts interface{}) string {
+	parser, _ := p.newParser(opts)
+	return p.helpText(parser)
+}
+
+func (p *Plugin) helpText(parser *flags.Parser) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s v%s\n", p.Name, strings.TrimPrefix(p.Version, "v"))
+	if len(p.Preamble) > 0 {
+		fmt.Fprintln(&b, p.Preamble)
 	}
+	parser.Options = flags.HelpFlag
+	var usage bytes.Buffer
+	parser.WriteHelp(&usage)
+	fmt.Fprintln(&b, usage.String())
 
-	return err
+	if len(p.Description) > 0 {
+		fmt.Fprintln(&b, p.Description)
+	}
+	return b.String()
+}
+
+/*
+Verbosity returns number of times the -v flag was given on the command line
+parsed by ParseArgs (0 if not given or not defined as []bool).
+
+	if check.Verbosity() > 2 {
+		check.AddMessage("Took %s", elapsed)
+	}
+
+*/
+func (p *Plugin) Verbosity() int {
+	return p.verbosity
+}
+
+/*
+AddVerboseMessage appends message to check output only if the -v flag was
+given on the command line at least level times, see Verbosity.
+
+	check.AddVerboseMessage(1, "Connected to %s", opts.Hostname)
+	check.AddVerboseMessage(3, "Server version %s", version)
+
+*/
+func (p *Plugin) AddVerboseMessage(level int, format string, args ...interface{}) {
+	if p.verbosity >= level {
+		p.AddMessage(format, args...)
+	}
 }
 
 /*
@@ -436,11 +2049,27 @@ UpdateStatus updates final exit status if the provided value is higher
 
 */
 func (p *Plugin) UpdateStatus(status Status) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.updateStatus(status)
+}
+
+// updateStatus raises status, p.mu must be held
+func (p *Plugin) updateStatus(status Status) {
+	status = p.clampStatus(status)
 	if int(status) > int(p.status) {
 		p.status = status
 	}
 }
 
+// clampStatus returns status lowered to MaxStatus if needed
+func (p *Plugin) clampStatus(status Status) Status {
+	if int(status) > int(p.MaxStatus) {
+		return p.MaxStatus
+	}
+	return status
+}
+
 /*
 Status returns current status.
 
@@ -448,9 +2077,25 @@ Status returns current status.
 
 */
 func (p *Plugin) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.status
 }
 
+// isInteger returns true if v is of integer type or a string representing
+// an integer
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return true
+	case string:
+		_, err := strconv.ParseInt(strings.TrimSpace(v.(string)), 10, 64)
+		return err == nil
+	default:
+		return false
+	}
+}
+
 func i2f(v interface{}) (float64, error) {
 	var f float64
 	var err error
@@ -2,8 +2,16 @@ package plugin
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"math"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type ExitHandler struct {
@@ -249,6 +257,158 @@ func TestAddResult(t *testing.T) {
 	}
 }
 
+func TestStatusFromMetricsOnly(t *testing.T) {
+	tests := []struct {
+		metricsOnly      bool
+		metrics          []MetricArgs
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			false,
+			[]MetricArgs{
+				{"m1", 5, []string{"", "10"}, ""},
+			},
+			WARNING, "WARNING: Manual warning | m1=5;10;;;\n",
+		},
+		{
+			true,
+			[]MetricArgs{
+				{"m1", 5, []string{"", "10"}, ""},
+			},
+			OK, "OK: Manual warning | m1=5;10;;;\n",
+		},
+		{
+			true,
+			[]MetricArgs{
+				{"m1", 5, []string{"", "10"}, ""},
+				{"m2", 50, []string{"", "10", "20"}, ""},
+			},
+			CRITICAL, "CRITICAL: Manual warning, m2 is 50 (outside 20) | m1=5;10;;; m2=50;10;20;;\n",
+		},
+		{
+			true,
+			nil,
+			OK, "OK: Manual warning\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.StatusFromMetricsOnly = test.metricsOnly
+			check.AddResult(WARNING, "Manual warning")
+			check.UpdateStatus(WARNING)
+			for _, m := range test.metrics {
+				check.AddMetric(m.name, m.value, m.uomAndThresholds...)
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestAssert(t *testing.T) {
+	tests := []struct {
+		ok               bool
+		failStatus       Status
+		addsOKMessages   bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{true, WARNING, false, OK, "OK: Start\n"},
+		{true, CRITICAL, false, OK, "OK: Start\n"},
+		{true, CRITICAL, true, OK, "OK: Start, Replication lag 5s\n"},
+		{false, WARNING, false, WARNING, "WARNING: Start, Replication lag 5s\n"},
+		{false, CRITICAL, false, CRITICAL, "CRITICAL: Start, Replication lag 5s\n"},
+		{false, CRITICAL, true, CRITICAL, "CRITICAL: Start, Replication lag 5s\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.AssertAddsOKMessages = test.addsOKMessages
+			check.AddMessage("Start")
+			if got := check.Assert(test.ok, test.failStatus, "Replication lag %ds", 5); got != test.ok {
+				t.Errorf("Got: %v, expected: %v", got, test.ok)
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestRequireMinOK(t *testing.T) {
+	tests := []struct {
+		results          []Status
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			[]Status{OK, OK, OK, CRITICAL, CRITICAL},
+			CRITICAL, "CRITICAL: r0, r1, r2, r3, r4\n",
+		},
+		{
+			[]Status{OK, OK, OK, OK, WARNING},
+			WARNING, "WARNING: r0, r1, r2, r3, r4\n",
+		},
+		{
+			[]Status{OK, OK, WARNING, CRITICAL, CRITICAL},
+			CRITICAL, "CRITICAL: r0, r1, r2, r3, r4, Less than 3 of 5 replicas healthy\n",
+		},
+		{
+			[]Status{OK, OK, OK, OK, OK},
+			OK, "OK: r0, r1, r2, r3, r4\n",
+		},
+		{
+			nil,
+			CRITICAL, "CRITICAL: Less than 3 of 5 replicas healthy\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.RequireMinOK(3, "Less than %d of %d replicas healthy", 3, 5)
+			for i, r := range test.results {
+				check.AddResult(r, "r%d", i)
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
 type ExitHelpersTest struct {
 	name             string
 	version          string
@@ -309,6 +469,62 @@ func TestExitHelpers(t *testing.T) {
 	}
 }
 
+func TestMaxStatus(t *testing.T) {
+	tests := []struct {
+		maxStatus        Status
+		run              func(*Plugin)
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			WARNING,
+			func(p *Plugin) { p.ExitCritical("Connection failed") },
+			WARNING, "WARNING: Connection failed\n",
+		},
+		{
+			WARNING,
+			func(p *Plugin) { p.ExitUnknown("Invalid arguments") },
+			WARNING, "WARNING: Invalid arguments\n",
+		},
+		{
+			OK,
+			func(p *Plugin) { p.ExitWarning("Degraded") },
+			OK, "OK: Degraded\n",
+		},
+		{
+			WARNING,
+			func(p *Plugin) {
+				p.AddResult(CRITICAL, "Serious fault detected!")
+				p.AddMetric("m1", 123.456, "", "10", "100")
+				p.Final()
+			},
+			WARNING, "WARNING: Serious fault detected!, m1 is 123.456 (outside 100) | m1=123.456;10;100;;\n",
+		},
+		{
+			UNKNOWN,
+			func(p *Plugin) { p.ExitCritical("Connection failed") },
+			CRITICAL, "CRITICAL: Connection failed\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		check := New("check_plugin", "v1.0")
+		check.MaxStatus = test.maxStatus
+		test.run(check)
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
 type MetricArgs struct {
 	name             string
 	value            interface{}
@@ -346,6 +562,31 @@ func TestAddMetric(t *testing.T) {
 			}, false,
 			OK, "OK: | 'white space'=123.456;;;;\n",
 		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"'quoted'", 123.456, nil, ""},
+			}, false,
+			OK, "OK: | quoted=123.456;;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"white space", 123.456, nil, ""},
+				{"'white space'", 0.0, nil, "Duplicated metric 'white space'"},
+				{"m1", 1, nil, ""},
+				{"'m1'", 0.0, nil, "Duplicated metric m1"},
+			}, false,
+			OK, "OK: | m1=1;;;; 'white space'=123.456;;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"'white space'", 123.456, nil, ""},
+				{"white space", 0.0, nil, "Duplicated metric 'white space'"},
+			}, false,
+			OK, "OK: | 'white space'=123.456;;;;\n",
+		},
 		{
 			"check_plugin", "v1.0",
 			[]MetricArgs{
@@ -382,7 +623,7 @@ func TestAddMetric(t *testing.T) {
 			[]MetricArgs{
 				{"percent", 23.45, []string{"%"}, ""},
 			}, true,
-			OK, "OK: percent is 23.45% | percent=23.45%;;;;\n",
+			OK, "OK: percent is 23.45% | percent=23.45%;;;0;100\n",
 		},
 		{
 			"check_plugin", "v1.0",
@@ -394,7 +635,7 @@ func TestAddMetric(t *testing.T) {
 		{
 			"check_plugin", "v1.0",
 			[]MetricArgs{
-				{"m1", 123.456, []string{"MB", "1", "2", "3"}, "Too many arguments"},
+				{"m1", 123.456, []string{"MB", "1", "2", "3", "4", "5"}, "Too many arguments"},
 			}, true,
 			OK, "OK:\n",
 		},
@@ -405,6 +646,24 @@ func TestAddMetric(t *testing.T) {
 			}, false,
 			WARNING, "WARNING: m1 is 123.456 (outside 100) | m1=123.456;100;;;\n",
 		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 87.2, []string{"GB", "80", "90", "0", "100"}, ""},
+				{"m2", 12, []string{"", "", "", "0"}, ""},
+				{"m3", 12, []string{"", "", "", "", "50.5"}, ""},
+				{"m4", 12, []string{"%", "", "", "", "20"}, ""},
+			}, false,
+			WARNING, "WARNING: m1 is 87.2GB (outside 80) | m1=87.2GB;80;90;0;100 m2=12;;;0; m3=12;;;;50.5 m4=12%;;;0;20\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 123.456, []string{"", "", "", "a"}, "Invalid format of min m1: a"},
+				{"m2", 123.456, []string{"", "", "", "0", "1:2"}, "Invalid format of max m2: 1:2"},
+			}, false,
+			OK, "OK:\n",
+		},
 		{
 			"check_plugin", "v1.0",
 			[]MetricArgs{
@@ -496,6 +755,43 @@ func TestAddMetric(t *testing.T) {
 			}, false,
 			CRITICAL, "CRITICAL: m1 is 123.456TB (inside @200) | m1=123.456TB;100;@200;;\n",
 		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"it's here", 10, []string{"", "5"}, ""},
+				{"a=b", 1, []string{}, ""},
+				{"a|b", 1, []string{}, `Invalid metric name "a|b", contains perfdata separator`},
+			}, false,
+			WARNING, "WARNING: 'it''s here' is 10 (outside 5) | 'a=b'=1;;;; 'it''s here'=10;5;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"temp", -5, []string{"", "100"}, ""},
+			}, false,
+			WARNING, "WARNING: temp is -5 (outside 100) | temp=-5;100;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"delta", -5, []string{"", "-10:100", "-20:100"}, ""},
+			}, false,
+			OK, "OK: | delta=-5;-10:100;-20:100;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"delta", -15, []string{"", "-10:100", "-20:100"}, ""},
+			}, false,
+			WARNING, "WARNING: delta is -15 (outside -10:100) | delta=-15;-10:100;-20:100;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"delta", -5, []string{"", "-10"}, "Invalid format of warning threshold delta: -10"},
+			}, false,
+			OK, "OK:\n",
+		},
 	}
 
 	for _, test := range tests {
@@ -526,37 +822,35 @@ func TestAddMetric(t *testing.T) {
 	}
 }
 
-type FinalOutputTest struct {
-	name             string
-	version          string
-	metrics          []MetricArgs
-	throwException   bool
-	expectedExitCode Status
-	expectedOutput   string
-}
-
-func TestFinal(t *testing.T) {
-	tests := []FinalOutputTest{
+func TestUOMSpaceInMessage(t *testing.T) {
+	tests := []AddMetricOutputTest{
 		{
 			"check_plugin", "v1.0",
-			nil, false,
-			OK, "OK:\n",
+			[]MetricArgs{
+				{"m1", 123.456, []string{"MB"}, ""},
+			}, true,
+			OK, "OK: m1 is 123.456 MB | m1=123.456MB;;;;\n",
 		},
 		{
 			"check_plugin", "v1.0",
 			[]MetricArgs{
 				{"m1", 123.456, nil, ""},
-			},
-			false,
-			OK, "OK: | m1=123.456;;;;\n",
+			}, true,
+			OK, "OK: m1 is 123.456 | m1=123.456;;;;\n",
 		},
 		{
 			"check_plugin", "v1.0",
 			[]MetricArgs{
-				{"m1", 123.456, nil, ""},
-			},
-			true,
-			CRITICAL, "CRITICAL: check_plugin panic: Forced exception\n",
+				{"m1", 123.456, []string{"MB", "100"}, ""},
+			}, false,
+			WARNING, "WARNING: m1 is 123.456 MB (outside 100) | m1=123.456MB;100;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 123.456, []string{"MB", "100", "@200"}, ""},
+			}, false,
+			CRITICAL, "CRITICAL: m1 is 123.456 MB (inside @200) | m1=123.456MB;100;@200;;\n",
 		},
 	}
 
@@ -566,15 +860,14 @@ func TestFinal(t *testing.T) {
 		func() {
 			check := New(test.name, test.version)
 			defer check.Final()
+			check.UOMSpaceInMessage = true
+			check.AllMetricsInOutput = test.includeAll
 			for _, m := range test.metrics {
 				metricErr := check.AddMetric(m.name, m.value, m.uomAndThresholds...)
-				if m.err != "" && metricErr.Error() != m.err {
-					t.Errorf("Got error: '%s', expected: '%s'", metricErr, m.err)
+				if metricErr != nil {
+					t.Errorf("Got error: '%s', expected none", metricErr)
 				}
 			}
-			if test.throwException {
-				panic("Forced exception")
-			}
 		}()
 
 		gotOutput := exitHandler.output.String()
@@ -588,44 +881,1783 @@ func TestFinal(t *testing.T) {
 	}
 }
 
-var OptionParseTest struct {
-	Hostname string `short:"H" long:"hostname" description:"Hostname"`
-}
-
-type ParseArgsTest struct {
-	name             string
-	version          string
-	args             []string
-	preamble         string
-	description      string
-	expectedHostname string
-	expectedExitCode Status
-	expectedOutput   string
-}
-
-func TestParseArgs(t *testing.T) {
-	tests := []ParseArgsTest{
+func TestThresholdsInOutput(t *testing.T) {
+	tests := []AddMetricOutputTest{
 		{
 			"check_plugin", "v1.0",
-			[]string{"-H", "localhost", "-h"},
-			"",
-			"",
-			"localhost",
-			UNKNOWN, `check_plugin v1.0
-Usage:
-  go-plugin.test [OPTIONS]
-
-Plugin Options:
-  -H, --hostname= Hostname
-
-Default Options:
-  -h, --help      Show this help message
-
-`,
+			[]MetricArgs{
+				{"m1", 123.456, []string{"MB", "200", "250"}, ""},
+				{"m2", 5, []string{"", "", "~:10"}, ""},
+				{"m3", 1, nil, ""},
+			}, true,
+			OK, "OK: m1 is 123.456MB (warn 200, crit 250), m2 is 5 (crit ~:10), m3 is 1 | m1=123.456MB;200;250;; m2=5;;~:10;; m3=1;;;;\n",
 		},
 		{
 			"check_plugin", "v1.0",
-			[]string{"-H", "localhost", "-h"},
+			[]MetricArgs{
+				{"m1", 123.456, []string{"MB", "100", "150"}, ""},
+			}, true,
+			WARNING, "WARNING: m1 is 123.456MB (outside 100) | m1=123.456MB;100;150;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 123.456, []string{"MB", "200", "250"}, ""},
+			}, false,
+			OK, "OK: | m1=123.456MB;200;250;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New(test.name, test.version)
+			defer check.Final()
+			check.ThresholdsInOutput = true
+			check.AllMetricsInOutput = test.includeAll
+			for _, m := range test.metrics {
+				metricErr := check.AddMetric(m.name, m.value, m.uomAndThresholds...)
+				if metricErr != nil {
+					t.Errorf("Got error: '%s', expected none", metricErr)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+type EmptyValueOutputTest struct {
+	metrics          []MetricArgs
+	includeAll       bool
+	escalate         bool
+	expectedExitCode Status
+	expectedOutput   string
+}
+
+func TestEmptyValueAsUnknown(t *testing.T) {
+	tests := []EmptyValueOutputTest{
+		{
+			[]MetricArgs{
+				{"m1", "", nil, ""},
+			}, false, false,
+			OK, "OK: | m1=U;;;;\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", " \t", []string{"MB", "10", "20"}, ""},
+				{"m2", 1, nil, ""},
+			}, false, false,
+			OK, "OK: | m1=U;10;20;; m2=1;;;;\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", "", []string{"MB"}, ""},
+			}, true, false,
+			OK, "OK: m1 is undetermined | m1=U;;;;\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", "  ", nil, ""},
+			}, false, true,
+			UNKNOWN, "UNKNOWN: | m1=U;;;;\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", "", []string{"MB", "1", "2", "3", "4", "5"}, "Too many arguments"},
+			}, false, true,
+			OK, "OK:\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", "abc", nil, "Invalid value of m1: abc"},
+			}, false, true,
+			OK, "OK:\n",
+		},
+		{
+			[]MetricArgs{
+				{"m1", "", []string{"", "garbage", "x:y"}, "Invalid format of warning threshold m1: garbage"},
+			}, false, true,
+			OK, "OK:\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.EmptyValueAsUnknown = true
+			check.EmptyValueEscalate = test.escalate
+			check.AllMetricsInOutput = test.includeAll
+			for _, m := range test.metrics {
+				metricErr := check.AddMetric(m.name, m.value, m.uomAndThresholds...)
+				if m.err == "" && metricErr != nil {
+					t.Errorf("Got error: '%s', expected none", metricErr)
+				}
+				if m.err != "" && (metricErr == nil || metricErr.Error() != m.err) {
+					t.Errorf("Got error: '%v', expected: '%s'", metricErr, m.err)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+
+	// without the option empty values are still rejected
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	if err := check.AddMetric("m1", ""); err == nil || err.Error() != "Invalid value of m1: " {
+		t.Errorf("Got error: '%v', expected: 'Invalid value of m1: '", err)
+	}
+}
+
+func TestPerfDataSeparator(t *testing.T) {
+	tests := []struct {
+		separator      string
+		expectedOutput string
+	}{
+		{"", "OK: All ok | m1=1;;;; m2=2MB;;;; m3=3;;;;\n"},
+		{" ", "OK: All ok | m1=1;;;; m2=2MB;;;; m3=3;;;;\n"},
+		{"\n", "OK: All ok | m1=1;;;;\n| m2=2MB;;;;\nm3=3;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			if len(test.separator) > 0 {
+				check.PerfDataSeparator = test.separator
+			}
+			check.AddMessage("All ok")
+			check.AddMetric("m1", 1)
+			check.AddMetric("m2", 2, "MB")
+			check.AddMetric("m3", 3)
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+	}
+}
+
+func TestShowMetricStatuses(t *testing.T) {
+	tests := []struct {
+		separator        string
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			" ",
+			UNKNOWN, "UNKNOWN: m2 is 15 (outside 10), m3 is 25 (inside @20:30) | m1=1;10;;; m2=15;10;;; m3=25;;@20:30;; m4=U;;;;\n" +
+				"m1: OK\n" +
+				"m2: WARNING (outside 10)\n" +
+				"m3: CRITICAL (inside @20:30)\n" +
+				"m4: UNKNOWN\n",
+		},
+		{
+			"\n",
+			UNKNOWN, "UNKNOWN: m2 is 15 (outside 10), m3 is 25 (inside @20:30) | m1=1;10;;;\n" +
+				"m1: OK\n" +
+				"m2: WARNING (outside 10)\n" +
+				"m3: CRITICAL (inside @20:30)\n" +
+				"m4: UNKNOWN | m2=15;10;;;\nm3=25;;@20:30;;\nm4=U;;;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.ShowMetricStatuses = true
+			check.EmptyValueAsUnknown = true
+			check.EmptyValueEscalate = true
+			check.PerfDataSeparator = test.separator
+			check.AddMetric("m1", 1, "", "10")
+			check.AddMetric("m2", 15, "", "10")
+			check.AddMetric("m3", 25, "", "", "@20:30")
+			check.AddMetric("m4", "")
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+type FinalOutputTest struct {
+	name             string
+	version          string
+	metrics          []MetricArgs
+	throwException   bool
+	expectedExitCode Status
+	expectedOutput   string
+}
+
+func TestFinal(t *testing.T) {
+	tests := []FinalOutputTest{
+		{
+			"check_plugin", "v1.0",
+			nil, false,
+			OK, "OK:\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 123.456, nil, ""},
+			},
+			false,
+			OK, "OK: | m1=123.456;;;;\n",
+		},
+		{
+			"check_plugin", "v1.0",
+			[]MetricArgs{
+				{"m1", 123.456, nil, ""},
+			},
+			true,
+			CRITICAL, "CRITICAL: check_plugin panic: Forced exception\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New(test.name, test.version)
+			defer check.Final()
+			for _, m := range test.metrics {
+				metricErr := check.AddMetric(m.name, m.value, m.uomAndThresholds...)
+				if m.err != "" && metricErr.Error() != m.err {
+					t.Errorf("Got error: '%s', expected: '%s'", metricErr, m.err)
+				}
+			}
+			if test.throwException {
+				panic("Forced exception")
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestPanicMessageFormat(t *testing.T) {
+	tests := []struct {
+		format         string
+		expectedOutput string
+	}{
+		{"{name} panic: {value}", "CRITICAL: check_plugin panic: Forced exception\n"},
+		{"", "CRITICAL: check_plugin panic: Forced exception\n"},
+		{"PANIC in {name} ({value})", "CRITICAL: PANIC in check_plugin (Forced exception)\n"},
+		{"Internal error: {value}, 100% sure", "CRITICAL: Internal error: Forced exception, 100% sure\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			check.PanicMessageFormat = test.format
+			defer check.Final()
+			panic("Forced exception")
+		}()
+
+		if exitHandler.code != CRITICAL {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, CRITICAL)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestDefer(t *testing.T) {
+	tests := []struct {
+		exit             func(*Plugin)
+		throwException   bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{nil, false, OK, "OK:\n"},
+		{nil, true, CRITICAL, "CRITICAL: check_plugin panic: Forced exception\n"},
+		{func(p *Plugin) { p.ExitWarning("Exit") }, false, WARNING, "WARNING: Exit\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		var order []int
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.Defer(func() { order = append(order, 1) })
+			check.Defer(func() { order = append(order, 2) })
+			if test.exit != nil {
+				test.exit(check)
+			}
+			if test.throwException {
+				panic("Forced exception")
+			}
+		}()
+
+		if len(order) != 2 || order[0] != 2 || order[1] != 1 {
+			t.Errorf("Got cleanup order: %v, expected: [2 1]", order)
+		}
+
+		gotOutput := exitHandler.output.String()
+		if !strings.HasPrefix(gotOutput, test.expectedOutput) {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestDeferExit(t *testing.T) {
+	for _, exit := range []func(*Plugin){
+		(*Plugin).Final,
+		func(p *Plugin) { p.ExitCritical("Failed") },
+	} {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddMessage("All ok")
+		check.Defer(func() { check.ExitWarning("Cleanup failed") })
+
+		done := make(chan bool)
+		go func() {
+			exit(check)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Got no exit when cleanup called ExitWarning")
+		}
+
+		expectedOutput := "WARNING: Cleanup failed\n"
+		if gotOutput := exitHandler.output.String(); !strings.HasPrefix(gotOutput, expectedOutput) {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, expectedOutput)
+		}
+		if exitHandler.code != WARNING {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, WARNING)
+		}
+	}
+}
+
+func TestShowDurationInMessage(t *testing.T) {
+	defer func() { pNow = time.Now }()
+
+	tests := []struct {
+		show             bool
+		messages         []FormatArgs
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{false, []FormatArgs{{"All ok", nil}}, OK, "OK: All ok | m1=1;;;;\n"},
+		{true, []FormatArgs{{"All ok", nil}}, OK, "OK: All ok (took 1.2s) | m1=1;;;;\n"},
+		{true, nil, OK, "OK: (took 1.2s) | m1=1;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		started := time.Unix(1500000000, 0)
+		pNow = func() time.Time { return started }
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.ShowDurationInMessage = test.show
+			for _, m := range test.messages {
+				check.AddMessage(m.format, m.params...)
+			}
+			check.AddMetric("m1", 1)
+			pNow = func() time.Time { return started.Add(1234 * time.Millisecond) }
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestDeferE(t *testing.T) {
+	tests := []struct {
+		status           Status
+		err              error
+		escalate         bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{OK, nil, false, OK, "OK: All ok\n"},
+		{OK, errors.New("connection reset"), false, WARNING, "WARNING: All ok, cleanup failed: connection reset\n"},
+		{CRITICAL, errors.New("connection reset"), false, CRITICAL, "CRITICAL: All ok, cleanup failed: connection reset\n"},
+		{OK, errors.New("connection reset"), true, CRITICAL, "CRITICAL: cleanup failed: connection reset, All ok\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.EscalateWarningToCritical = test.escalate
+			check.SortMessagesBySeverity = test.escalate
+			cleanupErr := test.err
+			check.DeferE(func() error { return cleanupErr })
+			check.AddResult(test.status, "All ok")
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestAddMetricCrit(t *testing.T) {
+	tests := []struct {
+		value            interface{}
+		crit             string
+		expectedError    string
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{87.5, "90", "", OK, "OK: | used=87.5%;;90;0;100\n"},
+		{95, "90", "", CRITICAL, "CRITICAL: used is 95% (outside 90) | used=95%;;90;0;100\n"},
+		{95, "", "", OK, "OK: | used=95%;;;0;100\n"},
+		{95, "a", "Invalid format of critical threshold used: a", OK, "OK:\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+
+		err := check.AddMetricCrit("used", test.value, "%", test.crit)
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+
+		check.Final()
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestOnFinal(t *testing.T) {
+	tests := []struct {
+		run              func(check *Plugin)
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			func(check *Plugin) {
+				defer check.Final()
+				check.AddResult(WARNING, "Almost full")
+				check.AddMetric("used", 85, "%")
+			},
+			WARNING, "WARNING: Almost full | used=85%;;;0;100",
+		},
+		{
+			func(check *Plugin) {
+				defer check.Final()
+				panic("Forced exception")
+			},
+			CRITICAL, "CRITICAL: check_plugin panic: Forced exception",
+		},
+		{
+			func(check *Plugin) {
+				check.ExitUnknown("Cannot connect")
+			},
+			UNKNOWN, "UNKNOWN: Cannot connect",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		var calls []string
+		var gotStatus Status
+		var gotOutput string
+		check := New("check_plugin", "v1.0")
+		check.Defer(func() { calls = append(calls, "cleanup") })
+		check.OnFinal(func(status Status, output string) {
+			calls = append(calls, "first")
+			gotStatus, gotOutput = status, output
+		})
+		check.OnFinal(func(status Status, output string) { calls = append(calls, "second") })
+
+		test.run(check)
+
+		if strings.Join(calls, ",") != "cleanup,first,second" {
+			t.Errorf("Got calls: %v, expected: [cleanup first second]", calls)
+		}
+		if gotStatus != test.expectedExitCode {
+			t.Errorf("Got callback status: %s, expected: %s", gotStatus, test.expectedExitCode)
+		}
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got callback output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+		if exitHandler.output.String() != test.expectedOutput+"\n" {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput+"\n")
+		}
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"version": "1.2.3",
+		"uptime":  "3d",
+		"conns":   42,
+		"Role":    "primary",
+		"ratio":   0.5,
+	}
+	expected := "OK: Role: primary, conns: 42, ratio: 0.5, uptime: 3d, version: 1.2.3\n"
+
+	for i := 0; i < 10; i++ {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddFields(fields)
+		check.AddFields(nil)
+		check.Final()
+
+		if exitHandler.output.String() != expected {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+		}
+	}
+}
+
+func TestStatusFromMessageCount(t *testing.T) {
+	tests := []struct {
+		count            int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{0, OK, "OK: | matches=0;2;4;;\n"},
+		{2, OK, "OK: error 1, error 2 | matches=2;2;4;;\n"},
+		{3, WARNING, "WARNING: error 1, error 2, error 3, matches is 3 (outside 2) | matches=3;2;4;;\n"},
+		{4, WARNING, "WARNING: error 1, error 2, error 3, error 4, matches is 4 (outside 2) | matches=4;2;4;;\n"},
+		{5, CRITICAL, "CRITICAL: error 1, error 2, error 3, error 4, error 5, matches is 5 (outside 4) | matches=5;2;4;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		for i := 1; i <= test.count; i++ {
+			check.AddMessage("error %d", i)
+		}
+		check.StatusFromMessageCount(2, 4)
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d for count %d", exitHandler.code, test.expectedExitCode, test.count)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestSetStatusLabels(t *testing.T) {
+	labels := map[Status]string{
+		OK:       "OK",
+		WARNING:  "WARNUNG",
+		CRITICAL: "KRITISCH",
+		UNKNOWN:  "UNBEKANNT",
+	}
+
+	tests := []struct {
+		status         Status
+		expectedOutput string
+		expectedLine   string
+	}{
+		{OK, "OK: Test\n", "OK: Test"},
+		{WARNING, "WARNUNG: Test\n", "WARNUNG: Test"},
+		{CRITICAL, "KRITISCH: Test\n", "KRITISCH: Test"},
+		{UNKNOWN, "UNBEKANNT: Test\n", "UNBEKANNT: Test"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		if err := check.SetStatusLabels(labels); err != nil {
+			t.Errorf("Got error: %s, expected none", err)
+		}
+		check.AddResult(test.status, "Test")
+
+		if line := check.StatusLine(); line != test.expectedLine {
+			t.Errorf("Got status line: '%s', expected: '%s'", line, test.expectedLine)
+		}
+		check.Final()
+		if exitHandler.code != test.status {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.status)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+
+	check := New("check_plugin", "v1.0")
+	check.SetStatusLabels(map[Status]string{CRITICAL: "KRITISCH"})
+	for _, invalid := range []map[Status]string{{Status(4): "X"}, {Status(-1): "X"}, {WARNING: ""}} {
+		if err := check.SetStatusLabels(invalid); err == nil {
+			t.Errorf("Got no error, expected error for labels %v", invalid)
+		}
+	}
+	check.AddResult(CRITICAL, "Test")
+	if line := check.StatusLine(); line != "KRITISCH: Test" {
+		t.Errorf("Got status line: '%s', expected: 'KRITISCH: Test'", line)
+	}
+}
+
+func TestSummaryFormat(t *testing.T) {
+	defer func() { pHostname = os.Hostname }()
+	pHostname = func() (string, error) { return "web01", nil }
+
+	tests := []struct {
+		format         string
+		hostname       bool
+		messages       []string
+		maxBytes       int
+		expectedOutput string
+	}{
+		{"", false, []string{"Test"}, 0, "CRITICAL: Test | m1=1;;;;\n"},
+		{"{status}: {message}", false, nil, 0, "KRITISCH: | m1=1;;;;\n"},
+		{"[{status}] {message}", false, []string{"Test", "Other"}, 0, "[KRITISCH] Test, Other | m1=1;;;;\n"},
+		{"[{status}] {message}", false, nil, 0, "[KRITISCH] | m1=1;;;;\n"},
+		{"[{status}] {message}", true, []string{"Test"}, 0, "[KRITISCH] [web01] Test | m1=1;;;;\n"},
+		{"{message} ({status})", true, []string{"Test"}, 0, "[web01] Test (KRITISCH) | m1=1;;;;\n"},
+		{"{message} ({status})", false, nil, 0, "(KRITISCH) | m1=1;;;;\n"},
+		{"{status}", false, []string{"Test"}, 0, "KRITISCH Test | m1=1;;;;\n"},
+		{"{message} ({status})", false, []string{"Test message"}, 30, "Test ... (KRITISCH) | m1=1;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		if len(test.format) > 0 {
+			check.SetStatusLabels(map[Status]string{CRITICAL: "KRITISCH"})
+		}
+		check.SummaryFormat = test.format
+		check.IncludeHostname = test.hostname
+		check.MaxOutputBytes = test.maxBytes
+		check.UpdateStatus(CRITICAL)
+		for _, msg := range test.messages {
+			check.AddMessage(msg)
+		}
+		check.AddMetric("m1", 1)
+		check.Final()
+
+		if exitHandler.code != CRITICAL {
+			t.Errorf("Got code: %d, expected: %d for format '%s'", exitHandler.code, CRITICAL, test.format)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for format '%s'", exitHandler.output.String(), test.expectedOutput, test.format)
+		}
+	}
+}
+
+func TestMaxResults(t *testing.T) {
+	tests := []struct {
+		max              int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{0, CRITICAL, "CRITICAL: Checking, ok 1, disk full, ok 2, ok 3, load high, ok 4\n"},
+		{10, CRITICAL, "CRITICAL: Checking, ok 1, disk full, ok 2, ok 3, load high, ok 4\n"},
+		{4, CRITICAL, "CRITICAL: Checking, disk full, ok 3, load high, ok 4\n"},
+		{2, CRITICAL, "CRITICAL: Checking, disk full, load high\n"},
+		{1, CRITICAL, "CRITICAL: Checking, disk full, load high\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.MaxResults = test.max
+		check.AddMessage("Checking")
+		check.AddResult(OK, "ok 1")
+		check.AddResult(CRITICAL, "disk full")
+		check.AddResult(OK, "ok 2")
+		check.AddResult(OK, "ok 3")
+		check.AddResult(WARNING, "load high")
+		check.AddResult(OK, "ok 4")
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for max %d", exitHandler.output.String(), test.expectedOutput, test.max)
+		}
+	}
+}
+
+func TestSummaryCounts(t *testing.T) {
+	tests := []struct {
+		summaryCounts    bool
+		results          []Status
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{false, []Status{OK, CRITICAL, WARNING, OK, CRITICAL}, CRITICAL, "CRITICAL: Checking, r0, r1, r2, r3, r4, load is 5 (outside 4) | load=5;4;;;\n"},
+		{true, []Status{OK, CRITICAL, WARNING, OK, CRITICAL}, CRITICAL, "CRITICAL: [2 CRITICAL, 1 WARNING, 2 OK] Checking, r0, r1, r2, r3, r4, load is 5 (outside 4) | load=5;4;;;\n"},
+		{true, []Status{UNKNOWN, OK}, UNKNOWN, "UNKNOWN: [1 UNKNOWN, 1 OK] Checking, r0, r1, load is 5 (outside 4) | load=5;4;;;\n"},
+		{true, nil, WARNING, "WARNING: Checking, load is 5 (outside 4) | load=5;4;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.SummaryCounts = test.summaryCounts
+		check.AddMessage("Checking")
+		for i, st := range test.results {
+			check.AddResult(st, "r%d", i)
+		}
+		check.AddMetric("load", 5, "", "4")
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestSortMessagesBySeverity(t *testing.T) {
+	tests := []struct {
+		sort           bool
+		expectedOutput string
+	}{
+		{false, "CRITICAL: Checking, ok 1, disk full, load is 5 (outside 4), ok 2, swap low, rta is 300ms (outside 200), ping failed | load=5;4;;; rta=300ms;;200;;\n"},
+		{true, "CRITICAL: disk full, rta is 300ms (outside 200), load is 5 (outside 4), swap low, Checking, ok 1, ok 2, ping failed | load=5;4;;; rta=300ms;;200;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.SortMessagesBySeverity = test.sort
+		check.AddMessage("Checking")
+		check.AddResult(OK, "ok 1")
+		check.AddResult(CRITICAL, "disk full")
+		check.AddMetric("load", 5, "", "4")
+		check.AddResult(OK, "ok 2")
+		check.AddResult(WARNING, "swap low")
+		check.AddMetric("rta", 300, "ms", "", "200")
+		check.AddMessage("ping failed")
+		check.Final()
+
+		if exitHandler.code != CRITICAL {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, CRITICAL)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestAddResultDoc(t *testing.T) {
+	tests := []struct {
+		format           Format
+		inOutput         bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{FormatNagios, false, CRITICAL, "CRITICAL: Checking, disk / full, disk /var full\n"},
+		{FormatNagios, true, CRITICAL, "CRITICAL: Checking, disk / full (see https://wiki/disk-full), disk /var full (see https://wiki/disk-full)\n"},
+		{
+			FormatJSON, false, CRITICAL,
+			`{"name":"check_plugin","status":"CRITICAL","exit_code":2,"message":"Checking, disk / full, disk /var full",` +
+				`"messages":["Checking","disk / full","disk /var full"],"metrics":[],"documentation":["https://wiki/disk-full"]}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.OutputFormat = test.format
+		check.DocumentationInOutput = test.inOutput
+		check.AddMessage("Checking")
+		for _, disk := range []string{"/", "/var"} {
+			if err := check.AddResultDoc(CRITICAL, "https://wiki/disk-full", "disk %s full", disk); err != nil {
+				t.Errorf("Got error: %s, expected none", err)
+			}
+		}
+		for _, docURL := range []string{"", "wiki/disk-full", "ftp://wiki/disk-full", "https://", "http://wiki/%zz"} {
+			err := check.AddResultDoc(UNKNOWN, docURL, "invalid")
+			if err == nil || err.Error() != "Invalid documentation URL: "+docURL {
+				t.Errorf("Got error: %v, expected 'Invalid documentation URL: %s'", err, docURL)
+			}
+		}
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	tests := []struct {
+		max            int
+		expectedOutput string
+	}{
+		{0, "CRITICAL: Disk /var is full, disk /home is full, rta is 124ms (outside 100) | rta=124ms;;100;;\n"},
+		{94, "CRITICAL: Disk /var is full, disk /home is full, rta is 124ms (outside 100) | rta=124ms;;100;;\n"},
+		{93, "CRITICAL: Disk /var is full, disk /home is full, rta is 124ms (outside ... | rta=124ms;;100;;\n"},
+		{50, "CRITICAL: Disk /var is full,... | rta=124ms;;100;;\n"},
+		{31, "CRITICAL: ... | rta=124ms;;100;;\n"},
+		{10, "CRITICAL: ... | rta=124ms;;100;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.MaxOutputBytes = test.max
+		check.AddMessage("Disk /var is full, disk /home is full")
+		check.AddMetric("rta", 124, "ms", "", "100")
+		check.Final()
+
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for max %d", exitHandler.output.String(), test.expectedOutput, test.max)
+		}
+		if test.max > 31 && exitHandler.output.Len()-1 > test.max {
+			t.Errorf("Got %d bytes, expected at most %d", exitHandler.output.Len()-1, test.max)
+		}
+	}
+
+	// multi-byte characters are not split
+	exitHandler := initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.MaxOutputBytes = 16
+	check.AddMessage("Zażółć gęślą")
+	check.Final()
+	if exitHandler.output.String() != "OK: Zażół...\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: Zażół...'", exitHandler.output.String())
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		format         Format
+		expectedStatus Status
+		expectedOutput string
+	}{
+		{FormatNagios, CRITICAL, "CRITICAL: Service localhost:80; rta is 24.558ms; pl is 100% (outside 50) | pl=100%;;50;0;100 rta=24.558ms;;;;\n"},
+		{
+			FormatJSON, CRITICAL,
+			`{"name":"check_plugin","status":"CRITICAL","exit_code":2,"message":"Service localhost:80; rta is 24.558ms; pl is 100% (outside 50)",` +
+				`"messages":["Service localhost:80","rta is 24.558ms","pl is 100% (outside 50)"],` +
+				`"metrics":[{"name":"pl","value":100,"uom":"%","status":2,"critical":{"min":0,"max":50,"invert":false}},{"name":"rta","value":24.558,"uom":"ms","status":0}]}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.OutputFormat = test.format
+		check.MessageSeparator = "; "
+		check.AllMetricsInOutput = true
+		check.AddMessage("Service localhost:80")
+		check.AddMetric("rta", 24.558, "ms")
+		check.AddMetric("pl", 100, "%", "", "50")
+
+		output, status := check.Render()
+		if status != test.expectedStatus {
+			t.Errorf("Got status: %d, expected: %d", status, test.expectedStatus)
+		}
+		if output != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", output, test.expectedOutput)
+		}
+		if exitHandler.output.Len() != 0 || exitHandler.code != OK {
+			t.Errorf("Got output: '%s' and code: %d, expected none", exitHandler.output.String(), exitHandler.code)
+		}
+
+		check.Final()
+		if exitHandler.output.String() != output {
+			t.Errorf("Got Final output: '%s', expected: '%s'", exitHandler.output.String(), output)
+		}
+		if exitHandler.code != status {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, status)
+		}
+	}
+}
+
+func TestRenderBeforeFinal(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.RequireMinOK(2, "Less than 2 replicas healthy")
+	check.StatusFromMessageCount(2, 3)
+	check.AddResult(OK, "replica 1")
+
+	output, status := check.Render()
+	expected := "CRITICAL: replica 1, Less than 2 replicas healthy | matches=1;2;3;;\n"
+	if status != CRITICAL || output != expected {
+		t.Errorf("Got: %d '%s', expected: %d '%s'", status, output, CRITICAL, expected)
+	}
+
+	check.AddResult(OK, "replica 2")
+	check.AddMessage("checked")
+	check.Final()
+
+	if exitHandler.code != WARNING {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, WARNING)
+	}
+	expected = "WARNING: replica 1, replica 2, checked, matches is 3 (outside 2) | matches=3;2;3;;\n"
+	if exitHandler.output.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+	}
+}
+
+func TestStatusPolicyAppliedLast(t *testing.T) {
+	tests := []struct {
+		budget         int
+		sinkErr        error
+		expectedOutput string
+	}{
+		{0, nil, "OK: | pl=0%;;;0;100 rta=24.558ms;;;;\n"},
+		{20, nil, "CRITICAL: perfdata truncated | pl=0%;;;0;100\n"},
+		{0, errors.New("refused"), "CRITICAL: sink failed: refused | pl=0%;;;0;100 rta=24.558ms;;;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.EscalateWarningToCritical = true
+		check.SortMessagesBySeverity = true
+		check.PerfDataByteBudget = test.budget
+		check.AddSink(func(*Plugin) error { return test.sinkErr })
+		check.AddMetric("rta", 24.558, "ms")
+		check.AddMetric("pl", 0, "%")
+		check.Final()
+
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestAddSink(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.AddMessage("Service localhost:80")
+	check.AddMetric("rta", 24.558, "ms")
+
+	var calls []string
+	var b bytes.Buffer
+	check.AddSink(func(c *Plugin) error {
+		calls = append(calls, "json")
+		return c.WriteJSON(&b)
+	})
+	check.AddSink(func(c *Plugin) error {
+		calls = append(calls, "socket")
+		return errors.New("connection refused")
+	})
+	check.Final()
+
+	if strings.Join(calls, ",") != "json,socket" {
+		t.Errorf("Got calls: %v, expected: [json socket]", calls)
+	}
+	if !strings.Contains(b.String(), `"status":"OK"`) {
+		t.Errorf("Got JSON: '%s', expected OK status", b.String())
+	}
+	if exitHandler.code != WARNING {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, WARNING)
+	}
+	expected := "WARNING: Service localhost:80, sink failed: connection refused | rta=24.558ms;;;;\n"
+	if exitHandler.output.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("m%02d", i)
+			if err := check.AddMetric(name, i, "", "", "48"); err != nil {
+				t.Errorf("Got error: %s, expected none", err)
+			}
+			check.AddResult(OK, "%s ok", name)
+			check.AddMessage("%s checked", name)
+			check.UpdateStatus(WARNING)
+		}(i)
+	}
+	wg.Wait()
+	check.Final()
+
+	if exitHandler.code != CRITICAL {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, CRITICAL)
+	}
+	output := exitHandler.output.String()
+	if n := strings.Count(output, " ok, "); n != 50 {
+		t.Errorf("Got %d ok results, expected 50", n)
+	}
+	if n := strings.Count(output, " checked"); n != 50 {
+		t.Errorf("Got %d messages, expected 50", n)
+	}
+	if !strings.Contains(output, "m49 is 49 (outside 48)") {
+		t.Errorf("Got output: '%s', expected to contain 'm49 is 49 (outside 48)'", output)
+	}
+	var tokens []string
+	for i := 0; i < 50; i++ {
+		tokens = append(tokens, fmt.Sprintf("m%02d=%d;;48;;", i, i))
+	}
+	if perfData := strings.Join(tokens, " ") + "\n"; !strings.HasSuffix(output, " | "+perfData) {
+		t.Errorf("Got output: '%s', expected to end with '| %s'", output, perfData)
+	}
+}
+
+func TestConcurrentReadWhileAdding(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("m%02d", i)
+			check.AddMetric(name, i, "B")
+			check.AddLongMessage("%s checked", name)
+			check.AddResult(OK, "%s ok", name)
+		}(i)
+		go func() {
+			defer wg.Done()
+			check.Capture()
+			check.StatusLine()
+			check.OutputHash()
+			check.ValidatePerfData()
+			check.WriteJSON(ioutil.Discard)
+			check.WriteMetrics(ioutil.Discard, MetricFormatGraphite)
+			check.WritePrometheus(ioutil.Discard)
+			check.WriteOpenMetrics(ioutil.Discard)
+			check.WriteSensuResult(ioutil.Discard, "check_plugin")
+			check.WritePassiveResult(ioutil.Discard, "host", "service")
+		}()
+	}
+	wg.Wait()
+	check.Final()
+
+	if exitHandler.code != OK {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, OK)
+	}
+	if n := strings.Count(exitHandler.output.String(), " checked"); n != 20 {
+		t.Errorf("Got %d long output lines, expected 20", n)
+	}
+}
+
+func TestGetAndRemoveMetric(t *testing.T) {
+	tests := []struct {
+		metricsOnly      bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{false, CRITICAL, "CRITICAL: Checking | load=0.5;1;2;;\n"},
+		{true, OK, "OK: Checking | load=0.5;1;2;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.StatusFromMetricsOnly = test.metricsOnly
+		check.AddMessage("Checking")
+		check.AddMetric("used space", 95, "%", "80", "90")
+		check.AddMetric("load", 0.5, "", "1", "2")
+
+		value, uom, warn, crit, ok := check.GetMetric("used space")
+		if !ok || value != 95 || uom != "%" || warn != "80" || crit != "90" {
+			t.Errorf("Got: %v, %s, %s, %s, %v, expected: 95, %%, 80, 90, true", value, uom, warn, crit, ok)
+		}
+		if _, _, _, _, ok := check.GetMetric("'used space'"); !ok {
+			t.Errorf("Got ok: false for quoted name, expected true")
+		}
+		if value, _, _, _, ok := check.GetMetric("missing"); ok || value != nil {
+			t.Errorf("Got: %v, %v for missing metric, expected: nil, false", value, ok)
+		}
+
+		if !check.RemoveMetric("'used space'") {
+			t.Errorf("Got removed: false, expected true")
+		}
+		if check.RemoveMetric("used space") {
+			t.Errorf("Got removed: true for removed metric, expected false")
+		}
+		if _, _, _, _, ok := check.GetMetric("used space"); ok {
+			t.Errorf("Got ok: true for removed metric, expected false")
+		}
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestMaxOutputLength(t *testing.T) {
+	tests := []struct {
+		max            int
+		expectedOutput string
+	}{
+		{0, "CRITICAL: /var is 95% (outside 90), /home is 92% (outside 90) | /home=92%;80;90;0;100 /var=95%;80;90;0;100\n/var: CRITICAL\n/home: CRITICAL\n"},
+		{125, "CRITICAL: /var is 95% (outside 90), /home is 92% (outside 90) | /home=92%;80;90;0;100 /var=95%;80;90;0;100\n/var: CRITICAL\n"},
+		{120, "CRITICAL: /var is 95% (outside 90), /home is 92% (outside 90) | /home=92%;80;90;0;100 /var=95%;80;90;0;100\n"},
+		{94, "CRITICAL: /var is 95% (outside 90), /home is 9... | /home=92%;80;90;0;100 /var=95%;80;90;0;100\n"},
+		{70, "CRITICAL: /var is 95% ... | /home=92%;80;90;0;100 /var=95%;80;90;0;100\n"},
+		{40, "CRITICAL: /va... | /home=92%;80;90;0;100\n"},
+		// the messages do not fit with even one metric
+		{20, "CRITICAL: /var is...\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.MaxOutputLength = test.max
+		check.AddMetric("/var", 95, "%", "80", "90")
+		check.AddMetric("/home", 92, "%", "80", "90")
+		check.AddLongMessage("/var: CRITICAL")
+		check.AddLongMessage("/home: CRITICAL")
+		check.Final()
+
+		if exitHandler.code != CRITICAL {
+			t.Errorf("Got code: %d, expected: %d for max %d", exitHandler.code, CRITICAL, test.max)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for max %d", exitHandler.output.String(), test.expectedOutput, test.max)
+		}
+		if test.max > 0 && exitHandler.output.Len()-1 > test.max {
+			t.Errorf("Got %d bytes, expected at most %d", exitHandler.output.Len()-1, test.max)
+		}
+	}
+}
+
+func TestAddInfoMetric(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	check.StatusFromMetricsOnly = true
+	if err := check.AddInfoMetric("connections", 1200, "500", "1000", ""); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+	if err := check.AddInfoMetric("used", 95, "", "@90:100", "%"); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+	check.AddMetric("load", 0.5)
+	if err := check.AddInfoMetric("bad", 1, "a", "", ""); err == nil || err.Error() != "Invalid format of warning threshold bad: a" {
+		t.Errorf("Got error: %v, expected: 'Invalid format of warning threshold bad: a'", err)
+	}
+
+	metrics := check.SortedMetrics()
+	if len(metrics) != 3 || !metrics[0].Info || metrics[1].Info || !metrics[2].Info {
+		t.Errorf("Got metrics: %+v, expected connections and used tagged as info", metrics)
+	}
+
+	check.Final()
+	if exitHandler.code != OK {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, OK)
+	}
+	expected := "OK: | connections=1200;500;1000;; load=0.5;;;; used=95%;;@90:100;0;100\n"
+	if exitHandler.output.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+	}
+}
+
+func TestEscalateWarningToCritical(t *testing.T) {
+	tests := []struct {
+		escalate         bool
+		value            int
+		maxStatus        Status
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{false, 85, UNKNOWN, WARNING, "WARNING: used is 85% (outside 80) | used=85%;80;90;0;100\n"},
+		{true, 85, UNKNOWN, CRITICAL, "CRITICAL: used is 85% (outside 80) | used=85%;80;90;0;100\n"},
+		{true, 50, UNKNOWN, OK, "OK: | used=50%;80;90;0;100\n"},
+		{true, 95, UNKNOWN, CRITICAL, "CRITICAL: used is 95% (outside 90) | used=95%;80;90;0;100\n"},
+		{true, 85, WARNING, WARNING, "WARNING: used is 85% (outside 80) | used=85%;80;90;0;100\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.EscalateWarningToCritical = test.escalate
+		check.MaxStatus = test.maxStatus
+		check.AddMetric("used", test.value, "%", "80", "90")
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestKeepMetricsOnExit(t *testing.T) {
+	tests := []struct {
+		keep           bool
+		expectedOutput string
+	}{
+		{false, "CRITICAL: Connection lost\n"},
+		{true, "CRITICAL: Connection lost | m1=1;;;; rta=24.558ms;50;100;;\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.KeepMetricsOnExit = test.keep
+		check.AddMessage("Connected")
+		check.AddMetric("rta", 24.558, "ms", "50", "100")
+		check.AddMetric("m1", 1)
+		check.ExitCritical("Connection lost")
+
+		if exitHandler.code != CRITICAL {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, CRITICAL)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestExitWithMetrics(t *testing.T) {
+	tests := []struct {
+		exit           func(*Plugin, string, ...interface{})
+		expectedCode   Status
+		expectedOutput string
+	}{
+		{(*Plugin).ExitOKWithMetrics, OK, "OK: Maintenance | m1=1;;;; rta=24.558ms;50;100;;\n"},
+		{(*Plugin).ExitWarningWithMetrics, WARNING, "WARNING: Maintenance | m1=1;;;; rta=24.558ms;50;100;;\n"},
+		{(*Plugin).ExitCriticalWithMetrics, CRITICAL, "CRITICAL: Maintenance | m1=1;;;; rta=24.558ms;50;100;;\n"},
+		{(*Plugin).ExitUnknownWithMetrics, UNKNOWN, "UNKNOWN: Maintenance | m1=1;;;; rta=24.558ms;50;100;;\n"},
+		{(*Plugin).ExitWarning, WARNING, "WARNING: Maintenance\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddMessage("Connected")
+		check.AddLongMessage("disk / ok")
+		check.AddMetric("rta", 24.558, "ms", "50", "100")
+		check.AddMetric("m1", 1)
+		test.exit(check, "%s", "Maintenance")
+
+		if exitHandler.code != test.expectedCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	done := make(chan bool, 1)
+	pOsExit = func(code Status) {
+		exitHandler.code = code
+		done <- true
+	}
+	var cleanups int
+	check := New("check_plugin", "v1.0")
+	check.Defer(func() { cleanups++ })
+	check.AddLongMessage("disk / ok")
+	check.AddMetric("m1", 1)
+	check.SetTimeout(time.Hour)
+	check.SetTimeout(10 * time.Millisecond)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Got no exit on timeout")
+	}
+
+	// completion after the timeout is ignored
+	check.AddMessage("Completed")
+	check.Final()
+
+	if exitHandler.code != UNKNOWN {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, UNKNOWN)
+	}
+	if exitHandler.output.String() != "UNKNOWN: check_plugin timed out after 10ms\n" {
+		t.Errorf("Got output: '%s', expected: 'UNKNOWN: check_plugin timed out after 10ms\n'", exitHandler.output.String())
+	}
+	if cleanups != 1 {
+		t.Errorf("Got %d cleanups run, expected 1", cleanups)
+	}
+
+	// completion before the timeout stops the timer
+	exitHandler = initExitHandler()
+	check = New("check_plugin", "v1.0")
+	check.SetTimeout(20 * time.Millisecond)
+	check.AddMessage("Completed")
+	check.Final()
+	time.Sleep(50 * time.Millisecond)
+
+	if exitHandler.code != OK {
+		t.Errorf("Got code: %d, expected: %d", exitHandler.code, OK)
+	}
+	if exitHandler.output.String() != "OK: Completed\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: Completed\n'", exitHandler.output.String())
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		fn             func(context.Context) error
+		timeout        time.Duration
+		expectedCode   Status
+		expectedOutput string
+	}{
+		{
+			func(context.Context) error { return nil },
+			time.Hour,
+			OK, "OK: Started | m1=1;;;;\n",
+		},
+		{
+			func(context.Context) error { return errors.New("Connection refused") },
+			time.Hour,
+			CRITICAL, "CRITICAL: Connection refused\n",
+		},
+		{
+			func(context.Context) error { panic("boom") },
+			time.Hour,
+			CRITICAL, "CRITICAL: check_plugin panic: boom\n",
+		},
+		{
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			10 * time.Millisecond,
+			UNKNOWN, "UNKNOWN: check_plugin timed out\n",
+		},
+		{
+			func(context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+			10 * time.Millisecond,
+			UNKNOWN, "UNKNOWN: check_plugin timed out\n",
+		},
+		{
+			func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			0,
+			UNKNOWN, "UNKNOWN: check_plugin cancelled: context canceled\n",
+		},
+	}
+
+	for i, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.AddMessage("Started")
+		check.AddMetric("m1", 1)
+
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		if test.timeout == 0 {
+			ctx, cancel = context.WithCancel(context.Background())
+			cancel()
+		}
+		check.Run(ctx, test.fn)
+		cancel()
+
+		if exitHandler.code != test.expectedCode {
+			t.Errorf("Got code: %d, expected: %d for test %d", exitHandler.code, test.expectedCode, i)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for test %d", exitHandler.output.String(), test.expectedOutput, i)
+		}
+	}
+}
+
+func TestStrictMessages(t *testing.T) {
+	tests := []struct {
+		strict           bool
+		message          string
+		expectedError    bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{false, "Clean message", false, OK, "OK: Clean message\n"},
+		{true, "Clean message", false, OK, "OK: Clean message\n"},
+		{false, "a | b", false, OK, "OK: a | b\n"},
+		{true, "a | b", true, OK, "OK:\n"},
+		{true, "line 1\nline 2", true, OK, "OK:\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.StrictMessages = test.strict
+		err := check.AddMessageE(test.message)
+		if (err != nil) != test.expectedError {
+			t.Errorf("Got error: %v, expected error: %v for %q", err, test.expectedError, test.message)
+		}
+		check.Final()
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+
+	for _, add := range []func(*Plugin){
+		func(check *Plugin) { check.AddMessage("a | b") },
+		func(check *Plugin) { check.AddResult(WARNING, "line 1\nline 2") },
+	} {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.StrictMessages = true
+		add(check)
+
+		expected := "UNKNOWN: Invalid message, contains perfdata separator or new line\n"
+		if exitHandler.code != UNKNOWN {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, UNKNOWN)
+		}
+		if exitHandler.output.String() != expected {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+		}
+	}
+}
+
+func TestAddLongMessage(t *testing.T) {
+	tests := []struct {
+		long              []string
+		perfDataSeparator string
+		expectedOutput    string
+	}{
+		{
+			nil, " ",
+			"WARNING: 1 disk almost full, /var is 85% (outside 80) | /=45%;80;90;0;100 /var=85%;80;90;0;100\n",
+		},
+		{
+			[]string{"/: 45% used", "/var: 85% used"}, " ",
+			"WARNING: 1 disk almost full, /var is 85% (outside 80) | /=45%;80;90;0;100 /var=85%;80;90;0;100\n/: 45% used\n/var: 85% used\n",
+		},
+		{
+			[]string{"/: 45% used", "/var: 85% used"}, "\n",
+			"WARNING: 1 disk almost full, /var is 85% (outside 80) | /=45%;80;90;0;100\n/: 45% used\n/var: 85% used | /var=85%;80;90;0;100\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.PerfDataSeparator = test.perfDataSeparator
+		check.AddResult(WARNING, "1 disk almost full")
+		check.AddMetric("/", 45, "%", "80", "90")
+		check.AddMetric("/var", 85, "%", "80", "90")
+		for _, line := range test.long {
+			check.AddLongMessage(line)
+		}
+		check.Final()
+
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestSetOutputAndExitFunc(t *testing.T) {
+	exitHandler := initExitHandler([]string{"-h"})
+	exitHandler.code = Status(-1)
+
+	var b bytes.Buffer
+	var codes []Status
+	check := New("check_plugin", "v1.0")
+	check.SetOutput(&b)
+	check.SetExitFunc(func(code Status) { codes = append(codes, code) })
+
+	var opts struct{}
+	check.ParseArgs(&opts)
+	if !strings.HasPrefix(b.String(), "check_plugin v1.0\nUsage:") {
+		t.Errorf("Got help output: '%s', expected to start with 'check_plugin v1.0\nUsage:'", b.String())
+	}
+
+	b.Reset()
+	check.AddResult(WARNING, "Almost full")
+	check.Final()
+	if b.String() != "WARNING: Almost full\n" {
+		t.Errorf("Got output: '%s', expected: 'WARNING: Almost full\n'", b.String())
+	}
+	if len(codes) != 2 || codes[0] != UNKNOWN || codes[1] != WARNING {
+		t.Errorf("Got exit codes: %v, expected: [3 1]", codes)
+	}
+
+	if exitHandler.length != 0 {
+		t.Errorf("Got output written to default output: '%s', expected none", exitHandler.output.String())
+	}
+	if exitHandler.code != Status(-1) {
+		t.Errorf("Got default exit with code: %d, expected none", exitHandler.code)
+	}
+}
+
+func TestWithOutput(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	var first, second bytes.Buffer
+	check := New("check_plugin", "v1.0")
+	check.AddMessage("All ok")
+
+	check.WithOutput(&first, func() {
+		check.Final()
+		check.WithOutput(&second, check.Final)
+		check.Final()
+	})
+	if first.String() != "OK: All ok\nOK: All ok\n" {
+		t.Errorf("Got first output: '%s', expected: 'OK: All ok\nOK: All ok\n'", first.String())
+	}
+	if second.String() != "OK: All ok\n" {
+		t.Errorf("Got second output: '%s', expected: 'OK: All ok\n'", second.String())
+	}
+
+	func() {
+		defer func() { recover() }()
+		check.WithOutput(&first, func() {
+			panic("Forced exception")
+		})
+	}()
+
+	check.Final()
+	if exitHandler.output.String() != "OK: All ok\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: All ok\n'", exitHandler.output.String())
+	}
+}
+
+func TestCapture(t *testing.T) {
+	exitHandler := initExitHandler()
+	exitHandler.code = Status(-1)
+
+	var cleanups int
+	check := New("check_plugin", "v1.0")
+	check.Defer(func() { cleanups++ })
+	check.AddMessage("All ok")
+	check.AddMetric("m1", 123.456, "", "100")
+
+	status, output := check.Capture()
+	if status != WARNING {
+		t.Errorf("Got status: %s, expected: %s", status, WARNING)
+	}
+	if output != "WARNING: All ok, m1 is 123.456 (outside 100) | m1=123.456;100;;;" {
+		t.Errorf("Got output: '%s', expected: 'WARNING: All ok, m1 is 123.456 (outside 100) | m1=123.456;100;;;'", output)
+	}
+
+	if exitHandler.length != 0 {
+		t.Errorf("Got output written: '%s', expected none", exitHandler.output.String())
+	}
+	if exitHandler.code != Status(-1) {
+		t.Errorf("Got exit with code: %d, expected no exit", exitHandler.code)
+	}
+	if cleanups != 0 {
+		t.Errorf("Got %d cleanups run, expected none", cleanups)
+	}
+
+	check.EscalateWarningToCritical = true
+	check.OutputFormat = FormatJSON
+	status, output = check.Capture()
+	rendered, renderedStatus := check.Render()
+	if status != CRITICAL || status != renderedStatus {
+		t.Errorf("Got status: %s, expected: %s", status, CRITICAL)
+	}
+	if output+"\n" != rendered || !strings.HasPrefix(output, "{") {
+		t.Errorf("Got output: '%s', expected JSON: '%s'", output, rendered)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	exitHandler := initExitHandler()
+	exitHandler.code = Status(-1)
+
+	check := New("check_plugin", "v1.0")
+	check.AddMetric("m1", 12)
+	if line := check.StatusLine(); line != "OK" {
+		t.Errorf("Got status line: '%s', expected: 'OK'", line)
+	}
+
+	check.AddResult(CRITICAL, "Connection refused")
+	check.AddMetric("m2", 123.456, "", "100")
+	line := check.StatusLine()
+	if line != "CRITICAL: Connection refused, m2 is 123.456 (outside 100)" {
+		t.Errorf("Got status line: '%s', expected: 'CRITICAL: Connection refused, m2 is 123.456 (outside 100)'", line)
+	}
+	if strings.ContainsAny(line, "|\n") {
+		t.Errorf("Got status line with perfdata or new line: '%s'", line)
+	}
+
+	// final adjustments and summary format are applied
+	check = New("check_plugin", "v1.0")
+	check.EscalateWarningToCritical = true
+	check.SummaryFormat = "[{status}] {message}"
+	check.AddResult(WARNING, "Slow")
+	if line := check.StatusLine(); line != "[CRITICAL] Slow" {
+		t.Errorf("Got status line: '%s', expected: '[CRITICAL] Slow'", line)
+	}
+
+	if exitHandler.length != 0 {
+		t.Errorf("Got output written: '%s', expected none", exitHandler.output.String())
+	}
+	if exitHandler.code != Status(-1) {
+		t.Errorf("Got exit with code: %d, expected no exit", exitHandler.code)
+	}
+}
+
+func TestIncludeHostname(t *testing.T) {
+	defer func() { pHostname = os.Hostname }()
+
+	tests := []struct {
+		include        bool
+		hostname       string
+		err            error
+		expectedOutput string
+		expectedJSON   string
+	}{
+		{
+			false, "web01", nil,
+			"OK: All ok | m1=1;;;;\n",
+			`{"name":"check_plugin","status":"OK","exit_code":0,"message":"All ok","messages":["All ok"],"metrics":[{"name":"m1","value":1,"status":0}]}` + "\n",
+		},
+		{
+			true, "web01", nil,
+			"OK: [web01] All ok | m1=1;;;;\n",
+			`{"name":"check_plugin","status":"OK","exit_code":0,"message":"All ok","messages":["All ok"],"metrics":[{"name":"m1","value":1,"status":0}],"hostname":"web01"}` + "\n",
+		},
+		{
+			true, "", errors.New("no hostname"),
+			"OK: All ok | m1=1;;;;\n",
+			`{"name":"check_plugin","status":"OK","exit_code":0,"message":"All ok","messages":["All ok"],"metrics":[{"name":"m1","value":1,"status":0}]}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		hostname, hostnameErr := test.hostname, test.err
+		pHostname = func() (string, error) { return hostname, hostnameErr }
+
+		check := New("check_plugin", "v1.0")
+		check.IncludeHostname = test.include
+		check.AddMessage("All ok")
+		check.AddMetric("m1", 1)
+
+		var b bytes.Buffer
+		check.WriteJSON(&b)
+		if b.String() != test.expectedJSON {
+			t.Errorf("Got JSON: '%s', expected: '%s'", b.String(), test.expectedJSON)
+		}
+
+		check.Final()
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+	}
+}
+
+var OptionParseTest struct {
+	Hostname string `short:"H" long:"hostname" description:"Hostname"`
+}
+
+type ParseArgsTest struct {
+	name             string
+	version          string
+	args             []string
+	preamble         string
+	description      string
+	expectedHostname string
+	expectedExitCode Status
+	expectedOutput   string
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []ParseArgsTest{
+		{
+			"check_plugin", "v1.0",
+			[]string{"-H", "localhost", "-h"},
+			"",
+			"",
+			"localhost",
+			UNKNOWN, `check_plugin v1.0
+Usage:
+  go-plugin.test [OPTIONS]
+
+Plugin Options:
+  -H, --hostname= Hostname
+
+Default Options:
+  -h, --help      Show this help message
+  -V, --version   Show plugin version
+  -v, --verbose   Verbose output, repeat for more details
+
+`,
+		},
+		{
+			"check_plugin", "v1.0",
+			[]string{"-H", "localhost", "-h"},
 			"Test output",
 			"Description:\n123",
 			"localhost",
@@ -639,6 +2671,8 @@ Plugin Options:
 
 Default Options:
   -h, --help      Show this help message
+  -V, --version   Show plugin version
+  -v, --verbose   Verbose output, repeat for more details
 
 Description:
 123
@@ -673,6 +2707,193 @@ Description:
 	}
 }
 
+func TestParseArgsVersion(t *testing.T) {
+	tests := []struct {
+		args             []string
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{[]string{"-V"}, UNKNOWN, "check_service v1.0.0\n"},
+		{[]string{"--version"}, UNKNOWN, "check_service v1.0.0\n"},
+		{[]string{"-H", "localhost"}, OK, ""},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler(test.args)
+
+		var opts struct {
+			Hostname string `short:"H" long:"hostname" description:"Hostname"`
+		}
+		check := New("check_service", "v1.0.0")
+		if err := check.ParseArgs(&opts); err != nil {
+			t.Errorf("Got error: %s for args: %v", err, test.args)
+		}
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d for args: %v", exitHandler.code, test.expectedExitCode, test.args)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for args: %v", exitHandler.output.String(), test.expectedOutput, test.args)
+		}
+	}
+
+	// -V defined by plugin options takes precedence
+	exitHandler := initExitHandler([]string{"-V", "/data", "-h"})
+	var opts struct {
+		Volume string `short:"V" long:"volume" description:"Volume" default:"/"`
+	}
+	check := New("check_service", "v1.0.0")
+	check.ParseArgs(&opts)
+	expected := `check_service v1.0.0
+Usage:
+  go-plugin.test [OPTIONS]
+
+Plugin Options:
+  -V, --volume=  Volume (default: /)
+
+Default Options:
+  -h, --help     Show this help message
+  -v, --verbose  Verbose output, repeat for more details
+
+`
+	if opts.Volume != "/data" {
+		t.Errorf("Got volume: '%s', expected: '/data'", opts.Volume)
+	}
+	if exitHandler.output.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expected)
+	}
+}
+
+func TestHelpText(t *testing.T) {
+	var opts struct {
+		Hostname string `short:"H" long:"hostname" description:"Hostname"`
+	}
+	expected := `check_plugin v1.0
+Checks the service
+Usage:
+  go-plugin.test [OPTIONS]
+
+Plugin Options:
+  -H, --hostname= Hostname
+
+Default Options:
+  -h, --help      Show this help message
+  -V, --version   Show plugin version
+  -v, --verbose   Verbose output, repeat for more details
+
+Description:
+123
+`
+
+	for _, exitOnHelp := range []bool{true, false} {
+		exitHandler := initExitHandler([]string{"-h"})
+		check := New("check_plugin", "v1.0")
+		check.Preamble = "Checks the service"
+		check.Description = "Description:\n123"
+		check.ExitOnHelp = exitOnHelp
+
+		if text := check.HelpText(&opts); text != expected {
+			t.Errorf("Got help text: '%s', expected: '%s'", text, expected)
+		}
+
+		err := check.ParseArgs(&opts)
+		if exitOnHelp {
+			if err != nil || exitHandler.code != UNKNOWN || exitHandler.output.String() != expected {
+				t.Errorf("Got error: %v, code: %d, output: '%s', expected none, UNKNOWN, '%s'", err, exitHandler.code, exitHandler.output.String(), expected)
+			}
+			continue
+		}
+		if err != ErrHelpRequested {
+			t.Errorf("Got error: %v, expected: %v", err, ErrHelpRequested)
+		}
+		if exitHandler.code != OK || exitHandler.output.Len() != 0 {
+			t.Errorf("Got code: %d, output: '%s', expected no exit", exitHandler.code, exitHandler.output.String())
+		}
+	}
+}
+
+func TestParseArgsVerbosity(t *testing.T) {
+	tests := []struct {
+		args              []string
+		expectedHostname  string
+		expectedPing      bool
+		expectedVerbosity int
+	}{
+		{[]string{"-H", "localhost"}, "localhost", false, 0},
+		{[]string{"-v", "-H", "localhost"}, "localhost", false, 1},
+		{[]string{"-vvv", "-H", "localhost"}, "localhost", false, 3},
+		{[]string{"-v", "-vv", "--verbose"}, "", false, 4},
+		{[]string{"-pv", "-Hlocalhost"}, "localhost", true, 1},
+		{[]string{"-vpH", "localhost"}, "localhost", true, 1},
+	}
+
+	for _, test := range tests {
+		initExitHandler(test.args)
+
+		var opts struct {
+			Hostname string `short:"H" long:"hostname" description:"Hostname"`
+			Ping     bool   `short:"p" long:"ping" description:"Ping"`
+			Verbose  []bool `short:"v" long:"verbose" description:"Verbose output"`
+		}
+		check := New("check_plugin", "v1.0")
+		if err := check.ParseArgs(&opts); err != nil {
+			t.Errorf("Got error: %s for args: %v", err, test.args)
+		}
+		if opts.Hostname != test.expectedHostname {
+			t.Errorf("Got hostname: '%s', expected: '%s' for args: %v", opts.Hostname, test.expectedHostname, test.args)
+		}
+		if opts.Ping != test.expectedPing {
+			t.Errorf("Got ping: %v, expected: %v for args: %v", opts.Ping, test.expectedPing, test.args)
+		}
+		if check.Verbosity() != test.expectedVerbosity {
+			t.Errorf("Got verbosity: %d, expected: %d for args: %v", check.Verbosity(), test.expectedVerbosity, test.args)
+		}
+	}
+}
+
+func TestAddVerboseMessage(t *testing.T) {
+	tests := []struct {
+		args           []string
+		expectedOutput string
+	}{
+		{[]string{"-H", "localhost"}, "OK: Connected\n"},
+		{[]string{"-H", "localhost", "-v"}, "OK: Connected, Host localhost\n"},
+		{[]string{"-vv", "--verbose", "-H", "localhost"}, "OK: Connected, Host localhost, Version 1.2\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler(test.args)
+
+		var opts struct {
+			Hostname string `short:"H" long:"hostname" description:"Hostname"`
+		}
+		check := New("check_plugin", "v1.0")
+		if err := check.ParseArgs(&opts); err != nil {
+			t.Errorf("Got error: %s for args: %v", err, test.args)
+		}
+		check.AddVerboseMessage(0, "Connected")
+		check.AddVerboseMessage(1, "Host %s", opts.Hostname)
+		check.AddVerboseMessage(3, "Version %s", "1.2")
+		check.Final()
+
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' for args: %v", exitHandler.output.String(), test.expectedOutput, test.args)
+		}
+	}
+
+	// -v defined by plugin options takes precedence
+	initExitHandler([]string{"-v", "/data"})
+	var opts struct {
+		Volume string `short:"v" long:"volume" description:"Volume"`
+	}
+	check := New("check_plugin", "v1.0")
+	if err := check.ParseArgs(&opts); err != nil {
+		t.Errorf("Got error: %s", err)
+	}
+	if opts.Volume != "/data" || check.Verbosity() != 0 {
+		t.Errorf("Got volume: '%s', verbosity: %d, expected: '/data', 0", opts.Volume, check.Verbosity())
+	}
+}
+
 func initExitHandler(args ...[]string) (exitHandler *ExitHandler) {
 	exitHandler = &ExitHandler{}
 	pOsExit = func(code Status) { exitHandler.code = code }
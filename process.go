@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"os"
+	"regexp"
+)
+
+// process describes a running process
+type process struct {
+	pid     int
+	name    string
+	cmdline string
+}
+
+/*
+CheckProcessRunning returns the number of running processes which name or
+command line matches namePattern (regular expression), with CRITICAL status
+if there are none. The check process itself and its parent (e.g. shell
+running it with the pattern in command line) are not counted. If the pattern
+is invalid or processes cannot be listed (only Linux is supported) UNKNOWN
+status is returned.
+
+	status, count := check.CheckProcessRunning("^nginx")
+	check.AddResult(status, "%d nginx processes", count)
+	check.AddMetric("procs", count)
+
+*/
+func (p *Plugin) CheckProcessRunning(namePattern string) (Status, int) {
+	re, err := regexp.Compile(namePattern)
+	if err != nil {
+		p.debugf("Invalid process pattern %s: %s", namePattern, err)
+		return UNKNOWN, 0
+	}
+
+	procs, err := pProcessList()
+	if err != nil {
+		p.debugf("Cannot list processes: %s", err)
+		return UNKNOWN, 0
+	}
+
+	self, parent := os.Getpid(), os.Getppid()
+	var count int
+	for _, proc := range procs {
+		if proc.pid == self || proc.pid == parent {
+			continue
+		}
+		if re.MatchString(proc.name) || re.MatchString(proc.cmdline) {
+			count++
+		}
+	}
+
+	if count == 0 {
+		return CRITICAL, 0
+	}
+	return OK, count
+}
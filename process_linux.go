@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var pProcessList = listProcesses
+
+// listProcesses returns processes found in /proc
+func listProcesses() ([]process, error) {
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+
+	procs := make([]process, 0, len(dirs))
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			// process has already exited
+			continue
+		}
+		cmdline, _ := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+		procs = append(procs, process{
+			pid:     pid,
+			name:    strings.TrimSpace(string(comm)),
+			cmdline: strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1)),
+		})
+	}
+	return procs, nil
+}
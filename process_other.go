@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"errors"
+)
+
+var pProcessList = listProcesses
+
+// listProcesses is supported on Linux only
+func listProcesses() ([]process, error) {
+	return nil, errors.New("Listing processes is not supported on this platform")
+}
@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCheckProcessRunning(t *testing.T) {
+	defer func() { pProcessList = listProcesses }()
+
+	procs := []process{
+		{101, "nginx", "nginx: master process /usr/sbin/nginx"},
+		{102, "nginx", "nginx: worker process"},
+		{103, "sshd", "/usr/sbin/sshd -D"},
+		{104, "python3", "/usr/bin/python3 /opt/app/worker.py"},
+		{os.Getpid(), "check_nginx", "./check_nginx --process ^nginx"},
+		{os.Getppid(), "sh", "sh -c ./check_nginx --process ^nginx"},
+	}
+
+	tests := []struct {
+		pattern        string
+		listErr        error
+		expectedStatus Status
+		expectedCount  int
+	}{
+		{"^nginx$", nil, OK, 2},
+		{"sshd", nil, OK, 1},
+		{"worker", nil, OK, 2},
+		{"worker\\.py", nil, OK, 1},
+		{"^httpd", nil, CRITICAL, 0},
+		{"check_nginx", nil, CRITICAL, 0},
+		{"(", nil, UNKNOWN, 0},
+		{"nginx", errors.New("no /proc"), UNKNOWN, 0},
+	}
+
+	for _, test := range tests {
+		listErr := test.listErr
+		pProcessList = func() ([]process, error) {
+			if listErr != nil {
+				return nil, listErr
+			}
+			return procs, nil
+		}
+
+		status, count := New("check_plugin", "v1.0").CheckProcessRunning(test.pattern)
+		if status != test.expectedStatus || count != test.expectedCount {
+			t.Errorf("%s: Got %s/%d, expected: %s/%d", test.pattern, status, count, test.expectedStatus, test.expectedCount)
+		}
+	}
+}
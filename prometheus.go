@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var pHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var prometheusInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// prometheusName converts name to valid Prometheus metric name
+func prometheusName(name string) string {
+	name = prometheusInvalidChars.ReplaceAllString(name, "_")
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// writeExposition writes check status gauge and calls writeMetric for each
+// metric with value (undetermined metrics are skipped), in order of names,
+// p.mu must be held. It is shared by Prometheus and OpenMetrics writers.
+func (p *Plugin) writeExposition(w io.Writer, writeMetric func(w io.Writer, name string, m *checkMetric, val float64)) {
+	status := prometheusName(p.Name) + "_status"
+	fmt.Fprintf(w, "# TYPE %s gauge\n", status)
+	fmt.Fprintf(w, "%s %d\n", status, p.status.ExitCode())
+
+	for _, name := range p.sortedMetricNames() {
+		m := p.metrics[name]
+		val, err := i2f(m.value)
+		if err != nil {
+			continue
+		}
+		writeMetric(w, name, m, val)
+	}
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+WritePrometheus writes check status and metrics in Prometheus text
+exposition format, e.g. for node_exporter textfile collector. The uom of
+metrics is added as "uom" label and thresholds are written as sibling
+metrics with "_warn" and "_crit" suffixes - the end of the threshold range,
+or its start for ranges without end. Counters ("c" uom) are written as
+counters, other metrics as gauges. Undetermined metrics and inverted
+thresholds are skipped.
+
+	f, _ := os.Create("/var/lib/node_exporter/check_service.prom.tmp")
+	check.WritePrometheus(f)
+	f.Close()
+	os.Rename(f.Name(), "/var/lib/node_exporter/check_service.prom")
+
+*/
+func (p *Plugin) WritePrometheus(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var b strings.Builder
+	p.writeExposition(&b, p.writePrometheusMetric)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writePrometheusMetric writes metric with its thresholds in Prometheus text
+// exposition format, see WritePrometheus
+func (p *Plugin) writePrometheusMetric(w io.Writer, name string, m *checkMetric, val float64) {
+	metric := prometheusName(name)
+	var labels string
+	if len(m.uom) > 0 {
+		labels = fmt.Sprintf(`{uom="%s"}`, prometheusLabelEscaper.Replace(m.uom))
+	}
+
+	metricType := "gauge"
+	if m.uom == "c" {
+		metricType = "counter"
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", metric, metricType)
+	fmt.Fprintf(w, "%s%s %v\n", metric, labels, val)
+
+	for _, t := range []struct{ suffix, threshold string }{{"_warn", m.warn}, {"_crit", m.critical}} {
+		if len(t.threshold) == 0 {
+			continue
+		}
+		r, err := p.parseThreshold(t.threshold, m.uom)
+		if err != nil || r.invert || !r.hasStart && !r.hasEnd {
+			// no single bound, e.g. "~:"
+			continue
+		}
+		bound := r.end
+		if !r.hasEnd {
+			bound = r.start
+		}
+		fmt.Fprintf(w, "# TYPE %s%s gauge\n", metric, t.suffix)
+		fmt.Fprintf(w, "%s%s%s %v\n", metric, t.suffix, labels, bound)
+	}
+}
+
+/*
+PushPrometheus pushes check status and metrics to Prometheus Pushgateway,
+grouped by job and labels. Errors (including non-2xx responses) are
+returned, so the caller can decide how they affect the check status.
+
+	err := check.PushPrometheus("http://pushgateway:9091", "check_service",
+		map[string]string{"instance": opts.Hostname})
+	if err != nil {
+		check.AddResult(plugin.WARNING, "Push failed: %s", err)
+	}
+
+*/
+func (p *Plugin) PushPrometheus(pushgatewayURL, job string, labels map[string]string) error {
+	path := []string{strings.TrimSuffix(pushgatewayURL, "/"), "metrics", "job", url.PathEscape(job)}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path = append(path, url.PathEscape(name), url.PathEscape(labels[name]))
+	}
+
+	var body bytes.Buffer
+	if err := p.WritePrometheus(&body); err != nil {
+		return err
+	}
+
+	resp, err := pHTTPClient.Post(strings.Join(path, "/"), "text/plain; version=0.0.4", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Pushgateway returned %s", resp.Status)
+	}
+	return nil
+}
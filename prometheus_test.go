@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrometheusName(t *testing.T) {
+	tests := []struct {
+		in   string
+		name string
+	}{
+		{"rta", "rta"},
+		{"packet loss", "packet_loss"},
+		{"check-service", "check_service"},
+		{"1min", "_1min"},
+		{"", "_"},
+	}
+
+	for _, test := range tests {
+		out := prometheusName(test.in)
+		if test.name != out {
+			t.Errorf("Got %s, expected %s", out, test.name)
+		}
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	initExitHandler()
+
+	check := New("check-service", "v1.0")
+	check.AllowCustomUOM = true
+	check.EmptyValueAsUnknown = true
+	check.AddMetric("rta", 24.558, "ms", "20", "50")
+	check.AddMetric("packet loss", 0, "%", "~:10", "@50:100")
+	check.AddMetric("jitter", "")
+	check.AddMetric("requests", 1234, "c")
+	check.AddMetric("free", 200, "MB", "100:", "50:")
+	check.AddMetric("temp", 21.5, `"C`)
+	check.AddMetric("queue", 3, "", "~:")
+
+	var b bytes.Buffer
+	if err := check.WritePrometheus(&b); err != nil {
+		t.Fatalf("Got error: %s, expected none", err)
+	}
+
+	expected := `# TYPE check_service_status gauge
+check_service_status 1
+# TYPE free gauge
+free{uom="MB"} 200
+# TYPE free_warn gauge
+free_warn{uom="MB"} 100
+# TYPE free_crit gauge
+free_crit{uom="MB"} 50
+# TYPE packet_loss gauge
+packet_loss{uom="%"} 0
+# TYPE packet_loss_warn gauge
+packet_loss_warn{uom="%"} 10
+# TYPE queue gauge
+queue 3
+# TYPE requests counter
+requests{uom="c"} 1234
+# TYPE rta gauge
+rta{uom="ms"} 24.558
+# TYPE rta_warn gauge
+rta_warn{uom="ms"} 20
+# TYPE rta_crit gauge
+rta_crit{uom="ms"} 50
+# TYPE temp gauge
+temp{uom="\"C"} 21.5
+`
+	if b.String() != expected {
+		t.Errorf("Got output: '%s', expected: '%s'", b.String(), expected)
+	}
+}
+
+func TestPushPrometheus(t *testing.T) {
+	tests := []struct {
+		code         int
+		labels       map[string]string
+		expectedPath string
+		err          string
+	}{
+		{200, nil, "/metrics/job/check_service", ""},
+		{202, map[string]string{"instance": "web/1", "dc": "eu"}, "/metrics/job/check_service/dc/eu/instance/web%2F1", ""},
+		{500, nil, "/metrics/job/check_service", "Pushgateway returned 500 Internal Server Error"},
+	}
+
+	for _, test := range tests {
+		var gotMethod, gotPath, gotBody string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotMethod, gotPath = r.Method, r.URL.EscapedPath()
+			b, _ := ioutil.ReadAll(r.Body)
+			gotBody = string(b)
+			w.WriteHeader(test.code)
+		}))
+
+		initExitHandler()
+		check := New("check-service", "v1.0")
+		check.EmptyValueAsUnknown = true
+		check.AddMetric("rta", 24.558, "ms", "20", "50")
+		check.AddMetric("packet loss", 0, "%")
+		check.AddMetric("jitter", "")
+
+		err := check.PushPrometheus(server.URL+"/", "check_service", test.labels)
+		server.Close()
+
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+		if gotMethod != "POST" {
+			t.Errorf("Got method: '%s', expected: 'POST'", gotMethod)
+		}
+		if gotPath != test.expectedPath {
+			t.Errorf("Got path: '%s', expected: '%s'", gotPath, test.expectedPath)
+		}
+		expectedBody := `# TYPE check_service_status gauge
+check_service_status 1
+# TYPE packet_loss gauge
+packet_loss{uom="%"} 0
+# TYPE rta gauge
+rta{uom="ms"} 24.558
+# TYPE rta_warn gauge
+rta_warn{uom="ms"} 20
+# TYPE rta_crit gauge
+rta_crit{uom="ms"} 50
+`
+		if gotBody != expectedBody {
+			t.Errorf("Got body: '%s', expected: '%s'", gotBody, expectedBody)
+		}
+	}
+
+	initExitHandler()
+	if err := New("check_plugin", "v1.0").PushPrometheus("http://127.0.0.1:0", "job", nil); err == nil {
+		t.Errorf("Got no error, expected connection error")
+	}
+}
@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+const rateSuffix = "/s"
+
+/*
+AddRateMetric adds metric with already computed per second rate value. The
+"/s" suffix is appended to unit (unless already present), so for example
+"B" becomes "B/s". The optional arguments are warning and critical
+thresholds, as in AddMetric. Rates of units other than those permitted by
+AddMetric (e.g. "q/s") require AllowCustomUOM.
+
+	check.AddRateMetric("rx", 1024.5, "B")
+	check.AddRateMetric("tx", 12.8, "MB/s", "100", "200")
+
+*/
+func (p *Plugin) AddRateMetric(name string, perSecond float64, unit string, args ...string) error {
+	base := strings.TrimSuffix(unit, rateSuffix)
+	if !validRateUnit(base) {
+		return fmt.Errorf("Invalid rate unit of %s: %s", name, unit)
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("Too many arguments")
+	}
+	return p.AddMetric(name, perSecond, append([]string{base + rateSuffix}, args...)...)
+}
+
+// validRateUnit checks if unit can be used as a base for rate unit - it has
+// to be a non-empty, valid uom which is not a percentage or counter
+func validRateUnit(unit string) bool {
+	if len(unit) == 0 || unit == "%" || unit == "c" {
+		return false
+	}
+	return !strings.ContainsRune(unit, '/') && validUOM(unit)
+}
@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"testing"
+)
+
+type RateMetricArgs struct {
+	name      string
+	value     float64
+	unit      string
+	threshold []string
+	err       string
+}
+
+func TestAddRateMetric(t *testing.T) {
+	tests := []struct {
+		metrics          []RateMetricArgs
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			[]RateMetricArgs{
+				{"rx", 1024.5, "B", nil, ""},
+			},
+			OK, "OK: | rx=1024.5B/s;;;;\n",
+		},
+		{
+			[]RateMetricArgs{
+				{"tx", 12.8, "MB/s", []string{"10", "20"}, ""},
+			},
+			WARNING, "WARNING: tx is 12.8MB/s (outside 10) | tx=12.8MB/s;10;20;;\n",
+		},
+		{
+			[]RateMetricArgs{
+				{"rx", 1, "", nil, "Invalid rate unit of rx: "},
+				{"rx", 1, "/s", nil, "Invalid rate unit of rx: /s"},
+				{"rx", 1, "%", nil, "Invalid rate unit of rx: %"},
+				{"rx", 1, "c", nil, "Invalid rate unit of rx: c"},
+				{"rx", 1, "B/m", nil, "Invalid rate unit of rx: B/m"},
+				{"rx", 1, "k B", nil, "Invalid rate unit of rx: k B"},
+				{"rx", 1, "B", []string{"1", "2", "3"}, "Too many arguments"},
+				{"queries", 1, "q/s", nil, "Invalid UOM for queries: q/s"},
+			},
+			OK, "OK:\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			for _, m := range test.metrics {
+				err := check.AddRateMetric(m.name, m.value, m.unit, m.threshold...)
+				if m.err == "" && err != nil {
+					t.Errorf("Got error: '%s', expected none", err)
+				}
+				if m.err != "" && (err == nil || err.Error() != m.err) {
+					t.Errorf("Got error: '%v', expected: '%s'", err, m.err)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
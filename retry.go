@@ -0,0 +1,90 @@
+package plugin
+
+import (
+	"context"
+	"time"
+)
+
+var pSleep = sleepContext
+
+// sleepContext waits for d or until ctx is done, returning ctx error then
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+WithRetries runs fn until it returns OK status without error, at most
+attempts times, waiting delay between attempts. The result of the last
+attempt is added with AddResult - if fn returned an error, it is added as
+UNKNOWN result with the error message. The added status is returned. No
+more attempts are made once the check timed out (see SetTimeout).
+
+	check.WithRetries(3, time.Second, func() (plugin.Status, string, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return plugin.UNKNOWN, "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return plugin.CRITICAL, fmt.Sprintf("HTTP %d", resp.StatusCode), nil
+		}
+		return plugin.OK, "HTTP 200", nil
+	})
+
+*/
+func (p *Plugin) WithRetries(attempts int, delay time.Duration, fn func() (Status, string, error)) Status {
+	return p.WithRetriesContext(context.Background(), attempts, delay, func(context.Context) (Status, string, error) {
+		return fn()
+	})
+}
+
+/*
+WithRetriesContext runs fn with ctx like WithRetries, but also stops retrying
+(without waiting the rest of delay) once ctx is done, e.g. the context of
+Run. The result of the last attempt is added as in WithRetries.
+
+	probe := func(ctx context.Context) (plugin.Status, string, error) {
+		return probeService(ctx, opts.Hostname)
+	}
+	check.Run(ctx, func(ctx context.Context) error {
+		check.WithRetriesContext(ctx, 3, time.Second, probe)
+		return nil
+	})
+
+*/
+func (p *Plugin) WithRetriesContext(ctx context.Context, attempts int, delay time.Duration, fn func(context.Context) (Status, string, error)) Status {
+	var status Status
+	var message string
+	var err error
+
+	for i := 1; ; i++ {
+		status, message, err = fn(ctx)
+		if err == nil && status == OK || i >= attempts {
+			break
+		}
+		p.debugf("Attempt %d of %d failed: %s %s %v", i, attempts, status, message, err)
+		if !p.expired() {
+			if sleepErr := pSleep(ctx, delay); sleepErr != nil {
+				p.debugf("No more attempts: %s", sleepErr)
+				break
+			}
+		}
+		if p.expired() {
+			p.debugf("No more attempts, the check timed out")
+			break
+		}
+	}
+
+	if err != nil {
+		status, message = UNKNOWN, err.Error()
+	}
+	p.AddResult(status, "%s", message)
+	return status
+}
@@ -0,0 +1,131 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type retryResult struct {
+	status  Status
+	message string
+	err     error
+}
+
+func TestWithRetries(t *testing.T) {
+	defer func() { pSleep = sleepContext }()
+
+	tests := []struct {
+		attempts         int
+		results          []retryResult
+		expectedCalls    int
+		expectedSleeps   int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			3, []retryResult{{OK, "Up", nil}},
+			1, 0, OK, "OK: Up\n",
+		},
+		{
+			3, []retryResult{{CRITICAL, "Down", nil}, {UNKNOWN, "", errors.New("Timeout")}, {OK, "Up", nil}},
+			3, 2, OK, "OK: Up\n",
+		},
+		{
+			3, []retryResult{{CRITICAL, "Down", nil}, {WARNING, "Slow", nil}, {CRITICAL, "Down again", nil}, {OK, "Up", nil}},
+			3, 2, CRITICAL, "CRITICAL: Down again\n",
+		},
+		{
+			2, []retryResult{{CRITICAL, "Down", nil}, {OK, "", errors.New("Connection refused")}},
+			2, 1, UNKNOWN, "UNKNOWN: Connection refused\n",
+		},
+		{
+			0, []retryResult{{WARNING, "Slow", nil}, {OK, "Up", nil}},
+			1, 0, WARNING, "WARNING: Slow\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		var sleeps int
+		pSleep = func(ctx context.Context, d time.Duration) error {
+			if d != 5*time.Second {
+				t.Errorf("Got delay: %s, expected: 5s", d)
+			}
+			sleeps++
+			return nil
+		}
+
+		var calls int
+		results := test.results
+		check := New("check_plugin", "v1.0")
+		status := check.WithRetries(test.attempts, 5*time.Second, func() (Status, string, error) {
+			r := results[calls]
+			calls++
+			return r.status, r.message, r.err
+		})
+		check.Final()
+
+		if calls != test.expectedCalls {
+			t.Errorf("Got calls: %d, expected: %d", calls, test.expectedCalls)
+		}
+		if sleeps != test.expectedSleeps {
+			t.Errorf("Got sleeps: %d, expected: %d", sleeps, test.expectedSleeps)
+		}
+		if status != test.expectedExitCode {
+			t.Errorf("Got status: %s, expected: %s", status, test.expectedExitCode)
+		}
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestWithRetriesContext(t *testing.T) {
+	tests := []struct {
+		stop             func(*Plugin, context.CancelFunc)
+		delay            time.Duration
+		expectedCalls    int
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{func(*Plugin, context.CancelFunc) {}, time.Millisecond, 3, OK, "OK: Up\n"},
+		// context done while waiting
+		{func(_ *Plugin, cancel context.CancelFunc) { cancel() }, time.Hour, 1, CRITICAL, "CRITICAL: Down\n"},
+		// check timed out
+		{func(p *Plugin, _ context.CancelFunc) { p.timedOut = true }, time.Hour, 1, CRITICAL, "CRITICAL: Down\n"},
+	}
+
+	for _, test := range tests {
+		initExitHandler()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls int
+		check := New("check_plugin", "v1.0")
+		status := check.WithRetriesContext(ctx, 3, test.delay, func(ctx context.Context) (Status, string, error) {
+			calls++
+			if calls < 3 {
+				test.stop(check, cancel)
+				return CRITICAL, "Down", nil
+			}
+			return OK, "Up", nil
+		})
+		output, _ := check.Render()
+		cancel()
+
+		if calls != test.expectedCalls {
+			t.Errorf("Got calls: %d, expected: %d", calls, test.expectedCalls)
+		}
+		if status != test.expectedExitCode {
+			t.Errorf("Got status: %s, expected: %s", status, test.expectedExitCode)
+		}
+		if output != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", output, test.expectedOutput)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type sensuResult struct {
+	Check   sensuCheck   `json:"check"`
+	Metrics sensuMetrics `json:"metrics"`
+}
+
+type sensuCheck struct {
+	Metadata sensuMetadata `json:"metadata"`
+	Status   int           `json:"status"`
+	Output   string        `json:"output"`
+}
+
+type sensuMetadata struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type sensuMetrics struct {
+	Points []sensuPoint `json:"points"`
+}
+
+type sensuPoint struct {
+	Name      string     `json:"name"`
+	Value     float64    `json:"value"`
+	Timestamp int64      `json:"timestamp"`
+	Tags      []sensuTag `json:"tags"`
+}
+
+type sensuTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+/*
+WriteSensuResult writes the check result as Sensu event JSON, with check
+status and output (as printed by Final) and metrics as data points.
+Undetermined metrics are not included in points. The host name (if
+IncludeHostname is set) and request ID (if set) are added as check labels
+and points tags.
+
+	check.WriteSensuResult(os.Stdout, "check_service")
+
+*/
+func (p *Plugin) WriteSensuResult(w io.Writer, checkName string) error {
+	c := p.finalCopy()
+	output, status := c.format()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := sensuResult{
+		Check: sensuCheck{
+			Metadata: sensuMetadata{Name: checkName},
+			Status:   status.ExitCode(),
+			Output:   output,
+		},
+		Metrics: sensuMetrics{
+			Points: make([]sensuPoint, 0, len(c.metrics)),
+		},
+	}
+
+	tags := []sensuTag{}
+	labels := make(map[string]string)
+	if hostname := c.hostname(); len(hostname) > 0 {
+		labels["hostname"] = hostname
+		tags = append(tags, sensuTag{"hostname", hostname})
+	}
+	if len(c.reqID) > 0 {
+		labels["request_id"] = c.reqID
+		tags = append(tags, sensuTag{"request_id", c.reqID})
+	}
+	if len(labels) > 0 {
+		result.Check.Metadata.Labels = labels
+	}
+
+	timestamp := pNow().Unix()
+	for _, name := range c.sortedMetricNames() {
+		val, err := i2f(c.metrics[name].value)
+		if err != nil {
+			continue
+		}
+		result.Metrics.Points = append(result.Metrics.Points, sensuPoint{
+			Name:      name,
+			Value:     val,
+			Timestamp: timestamp,
+			Tags:      tags,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(result)
+}
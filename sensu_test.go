@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWriteSensuResult(t *testing.T) {
+	pNow = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() { pNow = time.Now }()
+
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.EmptyValueAsUnknown = true
+	check.AddMessage("Service localhost:80")
+	check.AddMetric("rta", 24.558, "ms", "20", "50")
+	check.AddMetric("packet loss", 0, "%")
+	check.AddMetric("jitter", "")
+
+	var b bytes.Buffer
+	if err := check.WriteSensuResult(&b, "check_service"); err != nil {
+		t.Fatalf("Got error: '%s', expected none", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("Got invalid JSON: %s", err)
+	}
+
+	var expected map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"check": {
+			"metadata": {"name": "check_service"},
+			"status": 1,
+			"output": "WARNING: Service localhost:80, rta is 24.558ms (outside 20) | jitter=U;;;; 'packet loss'=0%;;;0;100 rta=24.558ms;20;50;;"
+		},
+		"metrics": {
+			"points": [
+				{"name": "packet loss", "value": 0, "timestamp": 1500000000, "tags": []},
+				{"name": "rta", "value": 24.558, "timestamp": 1500000000, "tags": []}
+			]
+		}
+	}`), &expected)
+
+	gotJSON, _ := json.Marshal(got)
+	expectedJSON, _ := json.Marshal(expected)
+	if string(gotJSON) != string(expectedJSON) {
+		t.Errorf("Got: '%s', expected: '%s'", gotJSON, expectedJSON)
+	}
+}
+
+func TestWriteSensuResultRequestID(t *testing.T) {
+	pNow = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() { pNow = time.Now }()
+
+	exitHandler := initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.SetRequestID("7d6b2c1e-0000-4000-8000-000000000001")
+	check.AddMessage("All ok")
+	check.AddMetric("rta", 24.558, "ms")
+
+	var b bytes.Buffer
+	if err := check.WriteSensuResult(&b, "check_service"); err != nil {
+		t.Fatalf("Got error: '%s', expected none", err)
+	}
+
+	expected := `{"check":{"metadata":{"name":"check_service","labels":{"request_id":"7d6b2c1e-0000-4000-8000-000000000001"}},` +
+		`"status":0,"output":"OK: All ok | rta=24.558ms;;;;"},` +
+		`"metrics":{"points":[{"name":"rta","value":24.558,"timestamp":1500000000,` +
+		`"tags":[{"name":"request_id","value":"7d6b2c1e-0000-4000-8000-000000000001"}]}]}}` + "\n"
+	if b.String() != expected {
+		t.Errorf("Got: '%s', expected: '%s'", b.String(), expected)
+	}
+
+	check.Final()
+	if exitHandler.output.String() != "OK: All ok | rta=24.558ms;;;;\n" {
+		t.Errorf("Got output: '%s', expected: 'OK: All ok | rta=24.558ms;;;;\n'", exitHandler.output.String())
+	}
+}
+
+func TestWriteSensuResultSink(t *testing.T) {
+	pNow = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() { pNow = time.Now }()
+
+	exitHandler := initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.EscalateWarningToCritical = true
+	check.RequireMinOK(1, "No replica healthy")
+	check.AddMetric("rta", 24.558, "ms", "20", "50")
+
+	var b bytes.Buffer
+	check.AddSink(func(p *Plugin) error {
+		return p.WriteSensuResult(&b, "check_service")
+	})
+	check.Final()
+
+	expected := `{"check":{"metadata":{"name":"check_service"},` +
+		`"status":2,"output":"CRITICAL: rta is 24.558ms (outside 20), No replica healthy | rta=24.558ms;20;50;;"},` +
+		`"metrics":{"points":[{"name":"rta","value":24.558,"timestamp":1500000000,"tags":[]}]}}` + "\n"
+	if b.String() != expected {
+		t.Errorf("Got: '%s', expected: '%s'", b.String(), expected)
+	}
+	expectedOutput := "CRITICAL: rta is 24.558ms (outside 20), No replica healthy | rta=24.558ms;20;50;;\n"
+	if exitHandler.output.String() != expectedOutput {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expectedOutput)
+	}
+}
@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+var pSocketTimeout = 2 * time.Second
+
+/*
+WriteToSocket connects to Unix domain socket at path and writes the check
+output line (as printed by Final, with trailing new line). Both connecting
+and writing time out after 2 seconds. The check status is not changed on
+failure, the error is returned.
+
+	if err := check.WriteToSocket("/run/agent/results.sock"); err != nil {
+		check.AddMessage("Cannot send result to agent: %s", err)
+	}
+
+*/
+func (p *Plugin) WriteToSocket(path string) error {
+	output, _ := p.Render()
+
+	conn, err := net.DialTimeout("unix", path, pSocketTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(pSocketTimeout)); err != nil {
+		return err
+	}
+	_, err = io.WriteString(conn, output)
+	return err
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteToSocket(t *testing.T) {
+	initExitHandler()
+
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "results.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- err.Error()
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	check := New("check_plugin", "v1.0")
+	check.EscalateWarningToCritical = true
+	check.AddResult(WARNING, "Almost full")
+	check.AddMetric("used", 85, "%")
+	if err := check.WriteToSocket(path); err != nil {
+		t.Fatalf("Got error: %s, expected none", err)
+	}
+
+	expected := "CRITICAL: Almost full | used=85%;;;0;100\n"
+	if got := <-received; got != expected {
+		t.Errorf("Got data: '%s', expected: '%s'", got, expected)
+	}
+
+	if err := check.WriteToSocket(filepath.Join(dir, "missing.sock")); err == nil {
+		t.Errorf("Got no error, expected error for missing socket")
+	}
+	if check.Status() != WARNING {
+		t.Errorf("Got status: %s, expected: %s", check.Status(), WARNING)
+	}
+}
@@ -0,0 +1,35 @@
+package plugin
+
+// MetricSpec describes metric with its thresholds, e.g. loaded from
+// configuration, see ApplyMetricSpec
+type MetricSpec struct {
+	Name  string
+	Value interface{}
+	UOM   string
+	Warn  string
+	Crit  string
+}
+
+/*
+ApplyMetricSpec adds metric for each of the specs, as AddMetric with the
+uom and thresholds of the spec. Invalid specs are skipped, errors of all of
+them are returned (nil if all metrics were added).
+
+	errs := check.ApplyMetricSpec([]plugin.MetricSpec{
+		{Name: "rta", Value: rta, UOM: "ms", Warn: cfg.RTAWarn, Crit: cfg.RTACrit},
+		{Name: "pl", Value: loss, UOM: "%", Crit: cfg.LossCrit},
+	})
+	for _, err := range errs {
+		check.AddResult(plugin.UNKNOWN, "%s", err)
+	}
+
+*/
+func (p *Plugin) ApplyMetricSpec(specs []MetricSpec) []error {
+	var errs []error
+	for _, spec := range specs {
+		if err := p.AddMetric(spec.Name, spec.Value, spec.UOM, spec.Warn, spec.Crit); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
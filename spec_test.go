@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestApplyMetricSpec(t *testing.T) {
+	exitHandler := initExitHandler()
+
+	check := New("check_plugin", "v1.0")
+	errs := check.ApplyMetricSpec([]MetricSpec{
+		{Name: "rta", Value: 24.558, UOM: "ms", Warn: "20", Crit: "50"},
+		{Name: "pl", Value: "abc", UOM: "%"},
+		{Name: "jitter", Value: 1.2, UOM: "ms", Warn: "x"},
+		{Name: "packet loss", Value: 0, UOM: "%", Crit: "10"},
+		{Name: "rta", Value: 30, UOM: "ms"},
+		{Name: "up", Value: 1},
+	})
+
+	expected := []string{
+		"Invalid value of pl: abc",
+		"Invalid format of warning threshold jitter: x",
+		"Duplicated metric rta",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Got errors: %v, expected: %v", errs, expected)
+	}
+	for i, err := range errs {
+		if err.Error() != expected[i] {
+			t.Errorf("Got error: '%s', expected: '%s'", err, expected[i])
+		}
+	}
+
+	check.Final()
+	expectedOutput := "WARNING: rta is 24.558ms (outside 20) | 'packet loss'=0%;;10;0;100 rta=24.558ms;20;50;; up=1;;;;\n"
+	if exitHandler.output.String() != expectedOutput {
+		t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), expectedOutput)
+	}
+
+	if errs := check.ApplyMetricSpec(nil); errs != nil {
+		t.Errorf("Got errors: %v, expected none", errs)
+	}
+}
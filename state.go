@@ -0,0 +1,273 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+DetectTransition loads the status stored in statefile by the previous run,
+compares it to the current status and stores the current status for the
+next run. If there is no previous state (or it is invalid and is
+overwritten), from equals to and changed is false.
+
+	from, to, changed, err := check.DetectTransition("/var/tmp/check_service.state")
+	if err == nil && changed && to == plugin.OK {
+		check.AddMessage("recovered from %s", from)
+	}
+
+*/
+func (p *Plugin) DetectTransition(statefile string) (from Status, to Status, changed bool, err error) {
+	to = p.Status()
+	from = to
+
+	data, err := ioutil.ReadFile(statefile)
+	switch {
+	case err == nil:
+		code, convErr := strconv.Atoi(strings.TrimSpace(string(data)))
+		if convErr != nil || code < int(OK) || code > int(UNKNOWN) {
+			p.debugf("Invalid state in %s", statefile)
+		} else {
+			from = Status(code)
+		}
+	case os.IsNotExist(err):
+		err = nil
+	default:
+		return from, to, false, err
+	}
+
+	if err = ioutil.WriteFile(statefile, []byte(strconv.Itoa(to.ExitCode())+"\n"), 0644); err != nil {
+		return from, to, false, err
+	}
+
+	return from, to, from != to, nil
+}
+
+/*
+WithMetricHistory loads metric values stored in statefile by the previous
+run, available via PreviousMetric, and makes Final store current values of
+all metrics (except undetermined) for the next run. If there is no previous
+state (the first run) no values are loaded and no error is returned.
+
+	if err := check.WithMetricHistory("/var/tmp/check_service.metrics"); err != nil {
+		check.AddMessage("Cannot load metric history: %s", err)
+	}
+	check.AddMetric("requests", requests, "c")
+	if prev, ok := check.PreviousMetric("requests"); ok {
+		check.AddMetric("requests_delta", requests-prev)
+	}
+
+*/
+func (p *Plugin) WithMetricHistory(statefile string) error {
+	p.historyFile = statefile
+	p.history = nil
+
+	data, err := ioutil.ReadFile(statefile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &p.history); err != nil {
+			return fmt.Errorf("Invalid state in %s", statefile)
+		}
+	case os.IsNotExist(err):
+	default:
+		return err
+	}
+	return nil
+}
+
+// PreviousMetric returns metric value stored by the previous run, see
+// WithMetricHistory. If the metric was not present false is returned.
+func (p *Plugin) PreviousMetric(name string) (float64, bool) {
+	v, ok := p.history[metricKey(name)]
+	return v, ok
+}
+
+// saveMetricHistory stores current metric values, see WithMetricHistory
+func (p *Plugin) saveMetricHistory() {
+	if len(p.historyFile) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	values := make(map[string]float64, len(p.metrics))
+	for name, metric := range p.metrics {
+		val, err := i2f(metric.value)
+		if err == nil && !math.IsNaN(val) && !math.IsInf(val, 0) {
+			values[name] = val
+		}
+	}
+	p.mu.Unlock()
+
+	data, err := json.Marshal(values)
+	if err == nil {
+		err = ioutil.WriteFile(p.historyFile, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		p.debugf("Cannot store metric history: %s", err)
+	}
+}
+
+// trendState is metric sample stored by CheckTrend
+type trendState struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+/*
+CheckTrend computes slope (change per second) of metric name between the
+sample stored in statefile by the previous run and the current value, and
+stores the current sample for the next run. If the slope in the bad
+direction (increasing if increasingIsBad, decreasing otherwise) exceeds
+critSlope or warnSlope, result with CRITICAL or WARNING status is added.
+The slope is added as "<name>_slope" metric, if it cannot be added (e.g.
+it was already added) UNKNOWN status and the error are returned. On the
+first run there is no trend and OK is returned. The metric has to be added
+before.
+
+	check.AddMetric("used", usedBytes, "B")
+	// alert if growing by more than 1MB/s or 10MB/s
+	check.CheckTrend("used", true, 1e6, 1e7, "/var/tmp/check_disk.trend")
+
+*/
+func (p *Plugin) CheckTrend(name string, increasingIsBad bool, warnSlope, critSlope float64, statefile string) (Status, error) {
+	key := metricKey(name)
+	value, _, _, _, ok := p.GetMetric(key)
+	if !ok {
+		return UNKNOWN, fmt.Errorf("No metric %s", quoteLabel(key))
+	}
+	val, err := i2f(value)
+	if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+		return UNKNOWN, fmt.Errorf("Invalid value of %s: %v", quoteLabel(key), value)
+	}
+
+	var previous *trendState
+	data, err := ioutil.ReadFile(statefile)
+	switch {
+	case err == nil:
+		previous = &trendState{}
+		if err := json.Unmarshal(data, previous); err != nil {
+			previous = nil
+			p.debugf("Invalid state in %s", statefile)
+		}
+	case !os.IsNotExist(err):
+		return UNKNOWN, err
+	}
+
+	now := pNow()
+	data, _ = json.Marshal(trendState{Time: now.UnixNano(), Value: val})
+	if err := ioutil.WriteFile(statefile, append(data, '\n'), 0644); err != nil {
+		return UNKNOWN, err
+	}
+
+	if previous == nil || now.UnixNano() <= previous.Time {
+		return OK, nil
+	}
+	slope := (val - previous.Value) / (float64(now.UnixNano()-previous.Time) / 1e9)
+	if err := p.AddMetric(key+"_slope", strconv.FormatFloat(slope, 'f', -1, 64)); err != nil {
+		return UNKNOWN, err
+	}
+
+	bad, direction := slope, "increasing"
+	if !increasingIsBad {
+		bad, direction = -slope, "decreasing"
+	}
+	status := OK
+	switch {
+	case bad > critSlope:
+		status = CRITICAL
+	case bad > warnSlope:
+		status = WARNING
+	default:
+		return OK, nil
+	}
+	p.AddResult(status, "%s %s at %s/s", quoteLabel(key), direction, strconv.FormatFloat(math.Abs(slope), 'g', 4, 64))
+	return status, nil
+}
+
+// counterStateFile returns path of the file storing counter samples of the
+// check instance, see CounterRates
+func (p *Plugin) counterStateFile() string {
+	name := p.Name
+	if len(p.StateKey) > 0 {
+		name += "-" + p.StateKey
+	}
+	name = strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(name)
+	dir := p.StateDir
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, name+".counters")
+}
+
+// writeStateFile writes data to temporary file in the directory of path and
+// renames it to path, so the state is replaced atomically and symlink at path
+// is not followed
+func writeStateFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// counterRate stores the counter sample and returns its per second rate since
+// the sample stored by the previous run. False is returned if there is no
+// previous sample (the first run) or the counter was reset or wrapped around.
+// The state file is locked while it is updated, as it is shared by all
+// counters of the check instance.
+func (p *Plugin) counterRate(key string, val float64) (float64, bool, error) {
+	statefile := p.counterStateFile()
+	unlock, err := lockStateFile(statefile)
+	if err != nil {
+		return 0, false, err
+	}
+	defer unlock()
+
+	samples := map[string]trendState{}
+	data, err := ioutil.ReadFile(statefile)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &samples); err != nil {
+			samples = map[string]trendState{}
+			p.debugf("Invalid state in %s", statefile)
+		}
+	case !os.IsNotExist(err):
+		p.debugf("Cannot load counters: %s", err)
+	}
+
+	now := pNow()
+	previous, ok := samples[key]
+	samples[key] = trendState{Time: now.UnixNano(), Value: val}
+	data, _ = json.Marshal(samples)
+	if err := writeStateFile(statefile, append(data, '\n')); err != nil {
+		p.debugf("Cannot store counters: %s", err)
+	}
+
+	switch {
+	case !ok:
+		p.debugf("No previous sample of counter %s", quoteLabel(key))
+		return 0, false, nil
+	case val < previous.Value:
+		p.debugf("Counter %s wrapped around or was reset, sample skipped", quoteLabel(key))
+		return 0, false, nil
+	case now.UnixNano() <= previous.Time:
+		return 0, false, nil
+	}
+	return (val - previous.Value) / (float64(now.UnixNano()-previous.Time) / 1e9), true, nil
+}
@@ -0,0 +1,22 @@
+package plugin
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockStateFile takes exclusive lock of lock file next to the state file at
+// path, waiting for other processes holding it. The returned function
+// releases the lock.
+func lockStateFile(path string) (func(), error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// closing the file releases the lock
+	return func() { f.Close() }, nil
+}
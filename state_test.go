@@ -0,0 +1,329 @@
+package plugin
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type DetectTransitionTest struct {
+	previous        string
+	current         Status
+	expectedFrom    Status
+	expectedTo      Status
+	expectedChanged bool
+}
+
+func TestDetectTransition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []DetectTransitionTest{
+		{"", OK, OK, OK, false},
+		{"", CRITICAL, CRITICAL, CRITICAL, false},
+		{"2\n", OK, CRITICAL, OK, true},
+		{"1\n", WARNING, WARNING, WARNING, false},
+		{"0", UNKNOWN, OK, UNKNOWN, true},
+		// invalid state is overwritten
+		{"garbage", WARNING, WARNING, WARNING, false},
+		{"7", OK, OK, OK, false},
+	}
+
+	for i, test := range tests {
+		statefile := filepath.Join(dir, "state")
+		os.Remove(statefile)
+		if test.previous != "" {
+			if err := ioutil.WriteFile(statefile, []byte(test.previous), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := New("check_plugin", "v1.0")
+		check.UpdateStatus(test.current)
+		from, to, changed, err := check.DetectTransition(statefile)
+
+		if err != nil {
+			t.Errorf("%d: Got error: '%s', expected none", i, err)
+		}
+		if from != test.expectedFrom || to != test.expectedTo || changed != test.expectedChanged {
+			t.Errorf("%d: Got %s -> %s (%v), expected: %s -> %s (%v)", i, from, to, changed,
+				test.expectedFrom, test.expectedTo, test.expectedChanged)
+		}
+
+		// current status is persisted for the next run
+		from, _, _, _ = New("check_plugin", "v1.0").DetectTransition(statefile)
+		if from != test.current {
+			t.Errorf("%d: Got persisted status: %s, expected: %s", i, from, test.current)
+		}
+	}
+}
+
+func TestWithMetricHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statefile := filepath.Join(dir, "check.metrics")
+
+	// first run, no history
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	if err := check.WithMetricHistory(statefile); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+	if _, ok := check.PreviousMetric("requests"); ok {
+		t.Errorf("Got previous requests value on the first run")
+	}
+	check.EmptyValueAsUnknown = true
+	check.AddMetric("requests", 1000, "c")
+	check.AddMetric("packet loss", 0.5, "%")
+	check.AddMetric("jitter", "")
+	check.Final()
+
+	// second run, metric set changed
+	exitHandler := initExitHandler()
+	check = New("check_plugin", "v1.0")
+	if err := check.WithMetricHistory(statefile); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+	tests := []struct {
+		name          string
+		expectedValue float64
+		expectedOK    bool
+	}{
+		{"requests", 1000, true},
+		{"packet loss", 0.5, true},
+		{"'packet loss'", 0.5, true},
+		{"jitter", 0, false},
+		{"latency", 0, false},
+	}
+	for _, test := range tests {
+		v, ok := check.PreviousMetric(test.name)
+		if v != test.expectedValue || ok != test.expectedOK {
+			t.Errorf("Got previous %s: %v %v, expected: %v %v", test.name, v, ok, test.expectedValue, test.expectedOK)
+		}
+	}
+	prev, _ := check.PreviousMetric("requests")
+	check.AddMetric("requests", 1250, "c")
+	check.AddMetric("requests_delta", 1250-prev)
+	check.AddMetric("latency", 12, "ms")
+	check.Final()
+	if exitHandler.output.String() != "OK: | latency=12ms;;;; requests=1250c;;;; requests_delta=250;;;;\n" {
+		t.Errorf("Got output: '%s'", exitHandler.output.String())
+	}
+
+	// third run sees the metrics of the second run only
+	initExitHandler()
+	check = New("check_plugin", "v1.0")
+	check.WithMetricHistory(statefile)
+	if _, ok := check.PreviousMetric("packet loss"); ok {
+		t.Errorf("Got previous value of metric removed in the second run")
+	}
+	if v, ok := check.PreviousMetric("latency"); v != 12 || !ok {
+		t.Errorf("Got previous latency: %v %v, expected: 12 true", v, ok)
+	}
+
+	ioutil.WriteFile(statefile, []byte("garbage"), 0644)
+	check = New("check_plugin", "v1.0")
+	if err := check.WithMetricHistory(statefile); err == nil || err.Error() != "Invalid state in "+statefile {
+		t.Errorf("Got error: %v, expected: 'Invalid state in %s'", err, statefile)
+	}
+}
+
+func TestCheckTrend(t *testing.T) {
+	defer func() { pNow = time.Now }()
+
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1500000000, 0)
+	tests := []struct {
+		increasingIsBad bool
+		values          [2]float64
+		expectedStatus  Status
+		expectedOutput  string
+	}{
+		{true, [2]float64{1000, 1050}, OK, "OK: | used=1050;;;; used_slope=5;;;;\n"},
+		{true, [2]float64{1000, 1150}, WARNING, "WARNING: used increasing at 15/s | used=1150;;;; used_slope=15;;;;\n"},
+		{true, [2]float64{1000, 1500}, CRITICAL, "CRITICAL: used increasing at 50/s | used=1500;;;; used_slope=50;;;;\n"},
+		{true, [2]float64{1000, 500}, OK, "OK: | used=500;;;; used_slope=-50;;;;\n"},
+		{false, [2]float64{1000, 500}, CRITICAL, "CRITICAL: used decreasing at 50/s | used=500;;;; used_slope=-50;;;;\n"},
+		{false, [2]float64{1000, 850}, WARNING, "WARNING: used decreasing at 15/s | used=850;;;; used_slope=-15;;;;\n"},
+		{false, [2]float64{1000, 1500}, OK, "OK: | used=1500;;;; used_slope=50;;;;\n"},
+	}
+
+	for i, test := range tests {
+		statefile := filepath.Join(dir, fmt.Sprintf("trend%d", i))
+
+		// first run, no trend
+		exitHandler := initExitHandler()
+		pNow = func() time.Time { return start }
+		check := New("check_plugin", "v1.0")
+		check.AddMetric("used", test.values[0])
+		status, err := check.CheckTrend("used", test.increasingIsBad, 10, 20, statefile)
+		if status != OK || err != nil {
+			t.Errorf("Got status: %s, error: %v, expected: OK, none on the first run", status, err)
+		}
+		check.Final()
+		if exitHandler.output.String() != fmt.Sprintf("OK: | used=%v;;;;\n", test.values[0]) {
+			t.Errorf("Got output: '%s' on the first run", exitHandler.output.String())
+		}
+
+		// second run, 10s later
+		exitHandler = initExitHandler()
+		pNow = func() time.Time { return start.Add(10 * time.Second) }
+		check = New("check_plugin", "v1.0")
+		check.AddMetric("used", test.values[1])
+		status, err = check.CheckTrend("used", test.increasingIsBad, 10, 20, statefile)
+		if status != test.expectedStatus || err != nil {
+			t.Errorf("Got status: %s, error: %v, expected: %s, none", status, err, test.expectedStatus)
+		}
+		check.Final()
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+
+	check := New("check_plugin", "v1.0")
+	if _, err := check.CheckTrend("missing", true, 10, 20, filepath.Join(dir, "missing")); err == nil || err.Error() != "No metric missing" {
+		t.Errorf("Got error: %v, expected: 'No metric missing'", err)
+	}
+
+	// slope metric already added
+	statefile := filepath.Join(dir, "duplicated")
+	check.AddMetric("used", 100)
+	check.AddMetric("used_slope", 0)
+	pNow = func() time.Time { return start }
+	check.CheckTrend("used", true, 10, 20, statefile)
+	pNow = func() time.Time { return start.Add(10 * time.Second) }
+	status, err := check.CheckTrend("used", true, 10, 20, statefile)
+	if status != UNKNOWN || err == nil || err.Error() != "Duplicated metric used_slope" {
+		t.Errorf("Got status: %s, error: %v, expected: %s, 'Duplicated metric used_slope'", status, err, UNKNOWN)
+	}
+}
+
+func TestCounterRates(t *testing.T) {
+	defer func() { pNow = time.Now }()
+
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1500000000, 0)
+	runs := []struct {
+		elapsed          time.Duration
+		bytes            uint64
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		// first run, no rate
+		{0, 1000, OK, "OK: | errors=0;;;;\n"},
+		{10 * time.Second, 6000, WARNING, "WARNING: bytes is 500 (outside 400) | bytes=500;400;;; errors=0;;;;\n"},
+		{20 * time.Second, 7000, OK, "OK: | bytes=100;400;;; errors=0;;;;\n"},
+		// wrapped around, sample skipped
+		{30 * time.Second, 100, OK, "OK: | errors=0;;;;\n"},
+		{40 * time.Second, 2100, OK, "OK: | bytes=200;400;;; errors=0;;;;\n"},
+	}
+
+	for i, run := range runs {
+		exitHandler := initExitHandler()
+		pNow = func() time.Time { return start.Add(run.elapsed) }
+		check := New("check_plugin", "v1.0")
+		check.CounterRates = true
+		check.StateDir = dir
+		if err := check.AddMetric("bytes", run.bytes, "c", "400"); err != nil {
+			t.Errorf("Got error: %s, expected none in run %d", err, i)
+		}
+		check.AddMetric("errors", 0)
+		check.Final()
+
+		if exitHandler.code != run.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d in run %d", exitHandler.code, run.expectedExitCode, i)
+		}
+		if exitHandler.output.String() != run.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s' in run %d", exitHandler.output.String(), run.expectedOutput, i)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "check_plugin.counters")); err != nil {
+		t.Errorf("Got error: %s, expected state file", err)
+	}
+
+	// informational counter, no metric on the first run
+	for i, elapsed := range []time.Duration{50 * time.Second, 60 * time.Second} {
+		pNow = func() time.Time { return start.Add(elapsed) }
+		check := New("check_plugin", "v1.0")
+		check.CounterRates = true
+		check.StateDir = dir
+		if err := check.AddInfoMetric("conns", 5+i*100, "", "", "c"); err != nil {
+			t.Errorf("Got error: %s, expected none in run %d", err, i)
+		}
+		value, _, _, _, ok := check.GetMetric("conns")
+		if i == 0 && ok {
+			t.Errorf("Got metric: %v, expected none on the first run", value)
+		}
+		if i == 1 && (!ok || value != float64(10)) {
+			t.Errorf("Got metric: %v, %v, expected: 10", value, ok)
+		}
+	}
+}
+
+func TestCounterRatesStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// temporary directory by default
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+	initExitHandler()
+	check := New("check_plugin", "v1.0")
+	check.CounterRates = true
+	if err := check.AddMetric("bytes", 1000, "c"); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "check_plugin.counters")); err != nil {
+		t.Errorf("Got error: %s, expected state file in temporary directory", err)
+	}
+	os.Remove(filepath.Join(dir, "check_plugin.counters"))
+
+	// state file of other instance linked to a file which must not be changed
+	target := filepath.Join(dir, "target")
+	if err := ioutil.WriteFile(target, []byte("keep\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	statefile := filepath.Join(dir, "check_plugin-web01.counters")
+	if err := os.Symlink(target, statefile); err != nil {
+		t.Fatal(err)
+	}
+
+	check.StateDir = dir
+	check.StateKey = "web01"
+	if err := check.AddMetric("bytes", 1000, "c"); err != nil {
+		t.Errorf("Got error: %s, expected none", err)
+	}
+
+	if data, _ := ioutil.ReadFile(target); string(data) != "keep\n" {
+		t.Errorf("Got linked file changed: '%s', expected: 'keep\n'", data)
+	}
+	if fi, err := os.Lstat(statefile); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("Got state file: %v, %v, expected regular file", fi, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "check_plugin.counters")); !os.IsNotExist(err) {
+		t.Errorf("Got error: %v, expected no state file shared by instances", err)
+	}
+}
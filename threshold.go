@@ -0,0 +1,369 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidThreshold = errors.New("Invalid threshold format")
+
+var thresholdNames = [2]string{"warning", "critical"}
+
+var thresholdShortNames = [2]string{"warn", "crit"}
+
+var strictThresholdRe = regexp.MustCompile(`^@?((-?(\d+(\.\d*)?|\.\d+)|~)(:(-?(\d+(\.\d*)?|\.\d+))?)?|(-?(\d+(\.\d*)?|\.\d+)|~):)$`)
+
+// thresholdRange is a parsed threshold range, values outside of it (or
+// inside, if inverted) breach the threshold
+type thresholdRange struct {
+	raw                string
+	start, end         float64
+	hasStart, hasEnd   bool
+	startOpen, endOpen bool
+	rawStart, rawEnd   string
+	invert             bool
+}
+
+func parseThreshold(threshold string) (*thresholdRange, error) {
+	var err error
+	r := &thresholdRange{raw: threshold}
+
+	arg := strings.TrimPrefix(threshold, "@")
+	r.invert = arg != threshold
+
+	thresh := strings.Split(arg, ":")
+
+	switch len(thresh) {
+	case 1:
+		// X is a shorthand for 0:X, parsed as such so that negative values
+		// breach the range by its implicit start (and X < 0 is invalid)
+		if len(thresh[0]) == 0 {
+			return nil, errInvalidThreshold
+		}
+		thresh = []string{"0", thresh[0]}
+		fallthrough
+	case 2:
+		// "~:" is unbounded on both ends, as in Nagios plugins
+		if thresh[0] != "~" {
+			r.rawStart, r.hasStart = thresh[0], true
+		}
+		if thresh[1] != "" {
+			r.rawEnd, r.hasEnd = thresh[1], true
+		}
+	default:
+		return nil, errInvalidThreshold
+	}
+
+	if r.hasStart {
+		if r.start, err = strconv.ParseFloat(r.rawStart, 64); err != nil {
+			return nil, errInvalidThreshold
+		}
+	}
+	if r.hasEnd {
+		if r.end, err = strconv.ParseFloat(r.rawEnd, 64); err != nil {
+			return nil, errInvalidThreshold
+		}
+	}
+	if r.hasStart && r.hasEnd && r.start > r.end {
+		return nil, errInvalidThreshold
+	}
+	return r, nil
+}
+
+// breached returns true if val is outside of the range (or inside, for
+// inverted ranges)
+func (r *thresholdRange) breached(val float64) bool {
+	outside := r.hasStart && (val < r.start || r.startOpen && val == r.start) ||
+		r.hasEnd && (val > r.end || r.endOpen && val == r.end)
+	return outside != r.invert
+}
+
+// breachedExact is like breached but compares val as rational number,
+// without floating point rounding
+func (r *thresholdRange) breachedExact(val *big.Rat) bool {
+	var outside bool
+	if r.hasStart {
+		if start, ok := new(big.Rat).SetString(r.rawStart); ok {
+			c := val.Cmp(start)
+			outside = c < 0 || r.startOpen && c == 0
+		}
+	}
+	if r.hasEnd && !outside {
+		if end, ok := new(big.Rat).SetString(r.rawEnd); ok {
+			c := val.Cmp(end)
+			outside = c > 0 || r.endOpen && c == 0
+		}
+	}
+	return outside != r.invert
+}
+
+// parseThreshold parses threshold of metric with uom, expanding unit
+// suffixes (see BinaryUnitPrefixes) and with StrictThresholdSyntax
+// validation if enabled
+func (p *Plugin) parseThreshold(threshold, uom string) (*thresholdRange, error) {
+	threshold, err := expandUnitSuffixes(threshold, uom, p.BinaryUnitPrefixes)
+	if err != nil {
+		return nil, errInvalidThreshold
+	}
+	if p.StrictThresholdSyntax && !strictThresholdRe.MatchString(threshold) {
+		return nil, errInvalidThreshold
+	}
+	return parseThreshold(threshold)
+}
+
+/*
+CheckThreshold evaluates value against Nagios threshold range without
+registering a metric. It returns true if value is outside of the range (or
+inside, for ranges prefixed with "@"). Semantics are the same as for
+thresholds passed to AddMetric:
+
+	CheckThreshold(11, "10")     // true, outside 0..10
+	CheckThreshold(5, "10:")     // true, below 10
+	CheckThreshold(-100, "~:10") // false
+	CheckThreshold(15, "@10:20") // true, inside 10..20
+
+Invalid ranges (e.g. "20:10") return error.
+
+*/
+func CheckThreshold(value float64, threshold string) (breached bool, err error) {
+	r, err := parseThreshold(threshold)
+	if err != nil {
+		return false, err
+	}
+	return r.breached(value), nil
+}
+
+// thresholdBreached checks metric value against threshold range, comparing
+// string values as rational numbers if ExactCompare is enabled
+func (p *Plugin) thresholdBreached(r *thresholdRange, val float64, value interface{}) bool {
+	if s, ok := value.(string); ok && p.ExactCompare {
+		if exact, ok := new(big.Rat).SetString(strings.TrimSpace(s)); ok {
+			return r.breachedExact(exact)
+		}
+	}
+	return r.breached(val)
+}
+
+type thresholdBinding struct {
+	warn     *string
+	critical *string
+}
+
+// args returns AddMetric arguments with uom (if provided) and bound thresholds
+func (b *thresholdBinding) args(uom ...string) []string {
+	args := []string{"", "", ""}
+	if len(uom) > 0 {
+		args[0] = uom[0]
+	}
+	if b.warn != nil {
+		args[1] = *b.warn
+	}
+	if b.critical != nil {
+		args[2] = *b.critical
+	}
+	return args
+}
+
+/*
+BindThreshold binds warning and critical thresholds command line options to
+the metric. Thresholds are read when the metric is added without explicit
+thresholds, and are validated in Final - if they could not be parsed the
+check exits with UNKNOWN status. Either of the pointers may be nil.
+
+	var opts struct {
+		Warning  string `short:"w" long:"warning" description:"Warning threshold"`
+		Critical string `short:"c" long:"critical" description:"Critical threshold"`
+	}
+	check.BindThreshold("rta", &opts.Warning, &opts.Critical)
+	check.ParseArgs(&opts)
+	...
+	check.AddMetric("rta", 24.558, "ms")
+
+*/
+func (p *Plugin) BindThreshold(metricName string, warnFlag, critFlag *string) {
+	p.bindings[metricKey(metricName)] = &thresholdBinding{
+		warn:     warnFlag,
+		critical: critFlag,
+	}
+}
+
+func (p *Plugin) validateBindings() error {
+	names := make([]string, 0, len(p.bindings))
+	for name := range p.bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b := p.bindings[name]
+		for i, t := range []*string{b.warn, b.critical} {
+			if t == nil || len(*t) == 0 {
+				continue
+			}
+			// uom of the metric is not known yet, it is checked by AddMetric
+			if _, err := p.parseThreshold(*t, ""); err != nil {
+				return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdNames[i], name, *t)
+			}
+		}
+	}
+	return nil
+}
+
+// Threshold is an interval with closed or open bounds, values outside of it
+// (or inside, if inverted) breach the threshold. Unbounded ends are
+// represented by negative and positive infinity.
+type Threshold struct {
+	Low      float64
+	High     float64
+	LowOpen  bool
+	HighOpen bool
+	Invert   bool
+}
+
+/*
+Range creates a new threshold interval. Use math.Inf(-1) and math.Inf(1) for
+unbounded low and high ends. Open bounds exclude the bound value itself from
+the interval, which allows to express intervals not supported by Nagios
+ranges, e.g. strictly between 10 and 20.
+
+	// alert unless 10 < value < 20
+	check.AddMetricT("rta", 15.2, "ms", plugin.Range(10, 20, true, true, false), nil)
+
+*/
+func Range(low, high float64, lowOpen, highOpen, invert bool) *Threshold {
+	return &Threshold{
+		Low:      low,
+		High:     high,
+		LowOpen:  lowOpen,
+		HighOpen: highOpen,
+		Invert:   invert,
+	}
+}
+
+/*
+ParseRange parses Nagios threshold range into threshold interval, with the
+same semantics as thresholds passed to AddMetric. The interval is serialized
+back to canonical form of the range by String.
+
+	t, err := plugin.ParseRange("~:100")
+	if err != nil {
+		check.ExitUnknown("Invalid threshold: %s", err)
+	}
+	check.AddMetricT("rta", 24.558, "ms", t, nil)
+
+*/
+func ParseRange(s string) (*Threshold, error) {
+	r, err := parseThreshold(s)
+	if err != nil {
+		return nil, err
+	}
+
+	t := Range(math.Inf(-1), math.Inf(1), false, false, r.invert)
+	if r.hasStart {
+		t.Low = r.start
+	}
+	if r.hasEnd {
+		t.High = r.end
+	}
+	return t, nil
+}
+
+// Check returns true if value breaches the threshold
+func (t *Threshold) Check(value float64) bool {
+	return t.thresholdRange().breached(value)
+}
+
+// String returns the closest Nagios range for the threshold. Nagios ranges
+// have closed bounds only, so open bounds are serialized as closed ones.
+func (t *Threshold) String() string {
+	var s string
+
+	high := ""
+	if !math.IsInf(t.High, 1) {
+		high = strconv.FormatFloat(t.High, 'f', -1, 64)
+	}
+
+	switch {
+	case math.IsInf(t.Low, -1):
+		s = "~:" + high
+	case t.Low == 0 && len(high) > 0:
+		s = high
+	default:
+		s = strconv.FormatFloat(t.Low, 'f', -1, 64) + ":" + high
+	}
+
+	if t.Invert {
+		s = "@" + s
+	}
+	return s
+}
+
+func (t *Threshold) thresholdRange() *thresholdRange {
+	r := &thresholdRange{
+		raw:       t.String(),
+		start:     t.Low,
+		end:       t.High,
+		hasStart:  !math.IsInf(t.Low, -1),
+		hasEnd:    !math.IsInf(t.High, 1),
+		startOpen: t.LowOpen,
+		endOpen:   t.HighOpen,
+		invert:    t.Invert,
+	}
+	r.rawStart = strconv.FormatFloat(t.Low, 'f', -1, 64)
+	r.rawEnd = strconv.FormatFloat(t.High, 'f', -1, 64)
+	return r
+}
+
+/*
+AddMetricT adds new metric to check's performance data, like AddMetric, with
+warning and critical thresholds provided as intervals (either may be nil).
+Note: in performance data the thresholds are serialized as the closest
+Nagios ranges, see Threshold.String.
+
+	check.AddMetricT("rta", 24.558, "ms", plugin.Range(0, 50, false, true, false), nil)
+
+*/
+func (p *Plugin) AddMetricT(name string, value interface{}, uom string, warn, crit *Threshold) error {
+	p.mu.Lock()
+	err := p.addMetricT(name, value, uom, warn, crit)
+	p.mu.Unlock()
+	return p.exitOnInvalidMessage(err)
+}
+
+// addMetricT adds metric with AddMetricT arguments, p.mu must be held
+func (p *Plugin) addMetricT(name string, value interface{}, uom string, warn, crit *Threshold) error {
+	key := metricKey(name)
+	if _, err := NormalizeMetricName(key); err != nil {
+		return err
+	}
+	if _, ok := p.metrics[key]; ok {
+		return fmt.Errorf("Duplicated metric %s", quoteLabel(key))
+	}
+	if !p.AllowCustomUOM && !permittedUOM(uom) {
+		return fmt.Errorf("Invalid UOM for %s: %s", quoteLabel(key), uom)
+	}
+
+	val, err := i2f(value)
+	if err != nil {
+		return fmt.Errorf("Invalid value of %s: %v", quoteLabel(key), value)
+	}
+
+	var thresholds [2]*thresholdRange
+	for i, t := range []*Threshold{warn, crit} {
+		if t == nil {
+			continue
+		}
+		if math.IsNaN(t.Low) || math.IsNaN(t.High) || t.Low > t.High {
+			return fmt.Errorf("Invalid format of %s threshold %s: %s", thresholdNames[i], quoteLabel(key), t)
+		}
+		thresholds[i] = t.thresholdRange()
+	}
+
+	return p.addMetric(key, value, val, uom, thresholds)
+}
@@ -0,0 +1,386 @@
+package plugin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckThreshold(t *testing.T) {
+	tests := []struct {
+		value     float64
+		threshold string
+		breached  bool
+		err       error
+	}{
+		{5, "10", false, nil},
+		{11, "10", true, nil},
+		{-1, "10", true, nil},
+		{5, "10:", true, nil},
+		{15, "10:", false, nil},
+		{-100, "~:10", false, nil},
+		{11, "~:10", true, nil},
+		{15, "10:20", false, nil},
+		{25, "10:20", true, nil},
+		{15, "@10:20", true, nil},
+		{25, "@10:20", false, nil},
+		{0, "20:10", false, errInvalidThreshold},
+		{0, "a", false, errInvalidThreshold},
+		{0, "1:2:3", false, errInvalidThreshold},
+		{123.456, "2000:100", false, errInvalidThreshold},
+		{123.456, "~:123", true, nil},
+		{-1e9, "~:123", false, nil},
+		{-1e9, "~:", false, nil},
+		{1e9, "@~:", true, nil},
+		{123.456, "@200", true, nil},
+		{200.5, "@200", false, nil},
+		{-1, "@200", false, nil},
+		{-5, "100", true, nil},
+		{-0.001, "100", true, nil},
+		{0, "100", false, nil},
+		{-5, "@100", false, nil},
+		{-5, "-10:100", false, nil},
+		{-15, "-10:100", true, nil},
+		{-5, "-10:-1", false, nil},
+		{-5, "-4:", true, nil},
+		{-5, "~:-6", true, nil},
+		{0, "-10", false, errInvalidThreshold},
+		{0, "", false, errInvalidThreshold},
+		{0, "~", false, errInvalidThreshold},
+	}
+
+	for _, test := range tests {
+		breached, err := CheckThreshold(test.value, test.threshold)
+		if err != test.err {
+			t.Errorf("%v/%s: Got err: %v, expected %v", test.value, test.threshold, err, test.err)
+		}
+		if breached != test.breached {
+			t.Errorf("%v/%s: Got breached: %v, expected %v", test.value, test.threshold, breached, test.breached)
+		}
+	}
+}
+
+var OptionBindThresholdTest struct {
+	Warning  string `short:"w" long:"warning" description:"Warning threshold"`
+	Critical string `short:"c" long:"critical" description:"Critical threshold"`
+}
+
+type BindThresholdTest struct {
+	args             []string
+	metricArgs       []string
+	expectedExitCode Status
+	expectedOutput   string
+}
+
+func TestBindThreshold(t *testing.T) {
+	tests := []BindThresholdTest{
+		{
+			[]string{},
+			[]string{"ms"},
+			OK, "OK: | pl=0%;;;0;100 rta=24.558ms;;;;\n",
+		},
+		{
+			[]string{"-w", "20", "-c", "50"},
+			[]string{"ms"},
+			WARNING, "WARNING: rta is 24.558ms (outside 20) | pl=0%;;;0;100 rta=24.558ms;20;50;;\n",
+		},
+		{
+			[]string{"-w", "20", "-c", "20"},
+			nil,
+			CRITICAL, "CRITICAL: rta is 24.558 (outside 20) | pl=0%;;;0;100 rta=24.558;20;20;;\n",
+		},
+		{
+			[]string{"-w", "20", "-c", "50"},
+			[]string{"ms", "", "10"},
+			CRITICAL, "CRITICAL: rta is 24.558ms (outside 10) | pl=0%;;;0;100 rta=24.558ms;;10;;\n",
+		},
+		{
+			[]string{"-w", "abc"},
+			[]string{"ms"},
+			UNKNOWN, "UNKNOWN: Invalid format of warning threshold rta: abc\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler(test.args)
+		OptionBindThresholdTest.Warning = ""
+		OptionBindThresholdTest.Critical = ""
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			check.BindThreshold("rta", &OptionBindThresholdTest.Warning, &OptionBindThresholdTest.Critical)
+			check.BindThreshold("pl", nil, nil)
+			if err := check.ParseArgs(&OptionBindThresholdTest); err != nil {
+				t.Errorf("Got error: '%s', expected none", err)
+			}
+			defer check.Final()
+			check.AddMetric("rta", 24.558, test.metricArgs...)
+			check.AddMetric("pl", 0, "%")
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestExactCompare(t *testing.T) {
+	tests := []struct {
+		value            interface{}
+		thresholds       []string
+		exact            bool
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			"1.00000000000000001", []string{"", "~:1"}, false,
+			OK, "OK: | m1=1.00000000000000001;~:1;;;\n",
+		},
+		{
+			"1.00000000000000001", []string{"", "~:1"}, true,
+			WARNING, "WARNING: m1 is 1.00000000000000001 (outside ~:1) | m1=1.00000000000000001;~:1;;;\n",
+		},
+		{
+			"0.29999999999999999", []string{"", "0.3:", "@0.3:0.4"}, false,
+			CRITICAL, "CRITICAL: m1 is 0.29999999999999999 (inside @0.3:0.4) | m1=0.29999999999999999;0.3:;@0.3:0.4;;\n",
+		},
+		{
+			"0.29999999999999999", []string{"", "0.3:", "@0.3:0.4"}, true,
+			WARNING, "WARNING: m1 is 0.29999999999999999 (outside 0.3:) | m1=0.29999999999999999;0.3:;@0.3:0.4;;\n",
+		},
+		{
+			0.30000000000000004, []string{"", "~:0.3"}, true,
+			WARNING, "WARNING: m1 is 0.30000000000000004 (outside ~:0.3) | m1=0.30000000000000004;~:0.3;;;\n",
+		},
+		{
+			"0.3", []string{"", "0.1:0.3"}, true,
+			OK, "OK: | m1=0.3;0.1:0.3;;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			defer check.Final()
+			check.ExactCompare = test.exact
+			if err := check.AddMetric("m1", test.value, test.thresholds...); err != nil {
+				t.Errorf("Got error: '%s', expected none", err)
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		threshold *Threshold
+		text      string
+		checks    map[float64]bool
+	}{
+		{Range(0, 10, false, false, false), "10", map[float64]bool{-1: true, 0: false, 10: false, 11: true}},
+		{Range(10, inf, false, false, false), "10:", map[float64]bool{9: true, 10: false, 1e9: false}},
+		{Range(-inf, 10, false, false, false), "~:10", map[float64]bool{-1e9: false, 10: false, 11: true}},
+		{Range(10, 20, false, false, true), "@10:20", map[float64]bool{9: false, 10: true, 20: true, 21: false}},
+		{Range(10, 20, true, true, false), "10:20", map[float64]bool{10: true, 10.1: false, 19.9: false, 20: true}},
+		{Range(10, 20, true, false, true), "@10:20", map[float64]bool{10: false, 10.1: true, 20: true}},
+		{Range(-5.5, 0.25, false, false, false), "-5.5:0.25", map[float64]bool{-5.5: false, 0.3: true}},
+	}
+
+	for _, test := range tests {
+		if test.threshold.String() != test.text {
+			t.Errorf("Got threshold: '%s', expected: '%s'", test.threshold, test.text)
+		}
+		for value, breached := range test.checks {
+			if test.threshold.Check(value) != breached {
+				t.Errorf("%s: Got breached for %v: %v, expected: %v", test.threshold, value, !breached, breached)
+			}
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	inf := math.Inf(1)
+	tests := []struct {
+		text      string
+		threshold *Threshold
+		canonical string
+	}{
+		{"10", Range(0, 10, false, false, false), "10"},
+		{"0:10", Range(0, 10, false, false, false), "10"},
+		{"10:", Range(10, inf, false, false, false), "10:"},
+		{"~:123", Range(-inf, 123, false, false, false), "~:123"},
+		{"80:100", Range(80, 100, false, false, false), "80:100"},
+		{"@200", Range(0, 200, false, false, true), "@200"},
+		{"@-5.5:0.25", Range(-5.5, 0.25, false, false, true), "@-5.5:0.25"},
+		{"1e3:", Range(1000, inf, false, false, false), "1000:"},
+		{"~:", Range(-inf, inf, false, false, false), "~:"},
+		{"@~:", Range(-inf, inf, false, false, true), "@~:"},
+		{"20:10", nil, ""},
+		{"-10", nil, ""},
+		{"", nil, ""},
+		{"a:b", nil, ""},
+	}
+
+	for _, test := range tests {
+		threshold, err := ParseRange(test.text)
+		if test.threshold == nil {
+			if err == nil {
+				t.Errorf("Got no error, expected error for '%s'", test.text)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Got error: %s, expected none for '%s'", err, test.text)
+			continue
+		}
+		if *threshold != *test.threshold {
+			t.Errorf("Got threshold: %+v, expected: %+v for '%s'", *threshold, *test.threshold, test.text)
+		}
+		if threshold.String() != test.canonical {
+			t.Errorf("Got string: '%s', expected: '%s'", threshold, test.canonical)
+		}
+		if _, err := ParseRange(threshold.String()); err != nil {
+			t.Errorf("Got error: %s, expected '%s' to round trip", err, threshold)
+		}
+		for _, value := range []float64{-1e9, -10, 0, 10, 100, 123, 200, 1e9} {
+			expected, _ := CheckThreshold(value, test.text)
+			if threshold.Check(value) != expected {
+				t.Errorf("Got breached for %v: %v, expected: %v for '%s'", value, !expected, expected, test.text)
+			}
+		}
+	}
+}
+
+type AddMetricTArgs struct {
+	name  string
+	value interface{}
+	uom   string
+	warn  *Threshold
+	crit  *Threshold
+	err   string
+}
+
+func TestAddMetricT(t *testing.T) {
+	tests := []struct {
+		metrics          []AddMetricTArgs
+		expectedExitCode Status
+		expectedOutput   string
+	}{
+		{
+			[]AddMetricTArgs{
+				{"temp", 15.2, "C", Range(10, 20, true, true, false), nil, ""},
+			},
+			OK, "OK: | temp=15.2C;10:20;;;\n",
+		},
+		{
+			[]AddMetricTArgs{
+				{"temp", 20, "C", Range(10, 20, true, true, false), Range(0, 30, false, false, false), ""},
+			},
+			WARNING, "WARNING: temp is 20C (outside 10:20) | temp=20C;10:20;30;;\n",
+		},
+		{
+			[]AddMetricTArgs{
+				{"temp", 25, "C", nil, Range(20, 30, false, false, true), ""},
+			},
+			CRITICAL, "CRITICAL: temp is 25C (inside @20:30) | temp=25C;;@20:30;;\n",
+		},
+		{
+			[]AddMetricTArgs{
+				{"temp", 1, "", nil, nil, ""},
+				{"temp", 1, "", nil, nil, "Duplicated metric temp"},
+				{"t2", "abc", "", nil, nil, "Invalid value of t2: abc"},
+				{"t3", 1, "", Range(20, 10, false, false, false), nil, "Invalid format of warning threshold t3: 20:10"},
+			},
+			OK, "OK: | temp=1;;;;\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		func() {
+			check := New("check_plugin", "v1.0")
+			check.AllowCustomUOM = true
+			defer check.Final()
+			for _, m := range test.metrics {
+				err := check.AddMetricT(m.name, m.value, m.uom, m.warn, m.crit)
+				if m.err == "" && err != nil {
+					t.Errorf("Got error: '%s', expected none", err)
+				}
+				if m.err != "" && (err == nil || err.Error() != m.err) {
+					t.Errorf("Got error: '%v', expected: '%s'", err, m.err)
+				}
+			}
+		}()
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+func TestStrictThresholdSyntax(t *testing.T) {
+	tests := []struct {
+		threshold string
+		lenient   bool
+		strict    bool
+	}{
+		{"10", true, true},
+		{"-10.5:", true, true},
+		{"~:10", true, true},
+		{"@.5:1.", true, true},
+		{"10:20", true, true},
+		{"+10", true, false},
+		{"10:+20", true, false},
+		{"1e3", true, false},
+		{"inf", true, false},
+		{"-Inf:0", true, false},
+		{"NaN", true, false},
+		{"0x1p4", true, false},
+		{" 10", false, false},
+		{"10 :20", false, false},
+		{"@@10", false, false},
+		{"~", false, false},
+		{"20:10", false, false},
+	}
+
+	for _, test := range tests {
+		for _, strict := range []bool{false, true} {
+			check := New("check_plugin", "v1.0")
+			check.StrictThresholdSyntax = strict
+			err := check.AddMetric("m1", 1, "", test.threshold)
+
+			valid := test.lenient
+			if strict {
+				valid = test.strict
+			}
+			if valid && err != nil {
+				t.Errorf("%s (strict: %v): Got error: '%s', expected none", test.threshold, strict, err)
+			}
+			if !valid && (err == nil || err.Error() != "Invalid format of warning threshold m1: "+test.threshold) {
+				t.Errorf("%s (strict: %v): Got error: '%v', expected: 'Invalid format of warning threshold m1: %s'",
+					test.threshold, strict, err, test.threshold)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unitPrefixes maps unit prefixes to powers of the base (1000 or 1024)
+var unitPrefixes = map[byte]float64{
+	'k': 1, 'K': 1, 'M': 2, 'G': 3, 'T': 4, 'P': 5, 'E': 6,
+}
+
+// parseUnitValue parses number with optional unit suffix and returns its
+// value in base units. Supported suffixes are SI prefixes (k/K, M, G, T, P,
+// E) and IEC prefixes (Ki, Mi, Gi, Ti, Pi, Ei), both optionally followed by
+// "B", and plain "B". IEC prefixes are powers of 1024, SI prefixes are
+// powers of 1000 unless binary is true.
+func parseUnitValue(s string, binary bool) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+
+	i := len(s)
+	for i > 0 && (s[i-1] >= 'a' && s[i-1] <= 'z' || s[i-1] >= 'A' && s[i-1] <= 'Z') {
+		i--
+	}
+	number, suffix := s[:i], s[i:]
+
+	v, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number: %s", s)
+	}
+
+	unit := strings.TrimSuffix(suffix, "B")
+	if len(unit) == 0 {
+		if len(suffix) == 0 {
+			return 0, fmt.Errorf("Invalid number: %s", s)
+		}
+		return v, nil
+	}
+
+	power, ok := unitPrefixes[unit[0]]
+	if !ok || len(unit) > 2 || len(unit) == 2 && unit[1] != 'i' {
+		return 0, fmt.Errorf("Invalid unit suffix: %s", suffix)
+	}
+
+	base := 1000.0
+	if binary || len(unit) == 2 {
+		base = 1024
+	}
+	return v * math.Pow(base, power), nil
+}
+
+// expandUnitSuffixes replaces numbers with unit suffixes in threshold range
+// with their values in uom of the metric, e.g. "10MB:1GB" becomes
+// "10000000:1000000000" for "B" uom and "10:1000" for "MB" uom. Numbers
+// without suffixes are kept as they are. Suffixes are allowed only for
+// metrics without uom (values are in base units) or with size uom,
+// optionally per second (e.g. "B", "KiB" or "MB/s").
+func expandUnitSuffixes(threshold, uom string, binary bool) (string, error) {
+	arg := strings.TrimPrefix(threshold, "@")
+	prefix := threshold[:len(threshold)-len(arg)]
+
+	scale := 1.0
+	if size := strings.TrimSuffix(uom, rateSuffix); len(size) > 0 {
+		v, err := parseUnitValue("1"+size, binary)
+		if err != nil || !strings.HasSuffix(size, "B") {
+			scale = 0
+		} else {
+			scale = v
+		}
+	}
+
+	parts := strings.Split(arg, ":")
+	for i, part := range parts {
+		if len(part) == 0 || part == "~" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(part, 64); err == nil {
+			continue
+		}
+		if scale == 0 {
+			return "", fmt.Errorf("Unit suffix not allowed for uom %s: %s", uom, part)
+		}
+		v, err := parseUnitValue(part, binary)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = strconv.FormatFloat(v/scale, 'f', -1, 64)
+	}
+	return prefix + strings.Join(parts, ":"), nil
+}
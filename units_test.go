@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"testing"
+)
+
+func TestParseUnitValue(t *testing.T) {
+	tests := []struct {
+		value         string
+		binary        bool
+		expectedValue float64
+		expectedError bool
+	}{
+		{"80", false, 80, false},
+		{"-1.5", false, -1.5, false},
+		{"100B", false, 100, false},
+		{"1kB", false, 1000, false},
+		{"2KB", false, 2000, false},
+		{"2KB", true, 2048, false},
+		{"80MB", false, 80000000, false},
+		{"80MB", true, 83886080, false},
+		{"90GB", false, 90000000000, false},
+		{"2T", false, 2000000000000, false},
+		{"1KiB", false, 1024, false},
+		{"80MiB", false, 83886080, false},
+		{"1.5GiB", false, 1610612736, false},
+		{"4Gi", false, 4294967296, false},
+		{"80XB", false, 0, true},
+		{"80MiiB", false, 0, true},
+		{"80BB", false, 0, true},
+		{"80Mb", false, 0, true},
+		{"MB", false, 0, true},
+		{"abc", false, 0, true},
+		{"", false, 0, true},
+	}
+
+	for _, test := range tests {
+		value, err := parseUnitValue(test.value, test.binary)
+		if (err != nil) != test.expectedError {
+			t.Errorf("Got error: %v, expected error: %v for %s", err, test.expectedError, test.value)
+		}
+		if value != test.expectedValue {
+			t.Errorf("Got value: %v, expected: %v for %s", value, test.expectedValue, test.value)
+		}
+	}
+}
+
+func TestThresholdUnitSuffixes(t *testing.T) {
+	tests := []struct {
+		value          interface{}
+		warn           string
+		crit           string
+		binary         bool
+		expectedError  string
+		expectedOutput string
+	}{
+		{
+			85000000000, "", "90GB", false, "",
+			"OK: | used=85000000000B;;90000000000;;\n",
+		},
+		{
+			95000000000, "", "90GB", false, "",
+			"CRITICAL: used is 95000000000B (outside 90000000000) | used=95000000000B;;90000000000;;\n",
+		},
+		{
+			95000000000, "", "90GB", true, "",
+			"OK: | used=95000000000B;;96636764160;;\n",
+		},
+		{
+			50000000000, "@10MiB:80GiB", "1.5TB", false, "",
+			"WARNING: used is 50000000000B (inside @10485760:85899345920) | used=50000000000B;@10485760:85899345920;1500000000000;;\n",
+		},
+		{
+			1000, "~:1KB", "", false, "",
+			"OK: | used=1000B;~:1000;;;\n",
+		},
+		{
+			1000, "", "90XB", false, "Invalid format of critical threshold used: 90XB",
+			"OK:\n",
+		},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+		check.BinaryUnitPrefixes = test.binary
+
+		err := check.AddMetric("used", test.value, "B", test.warn, test.crit)
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+
+		check.Final()
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
+
+func TestThresholdUnitSuffixesUOM(t *testing.T) {
+	tests := []struct {
+		uom            string
+		value          interface{}
+		crit           string
+		expectedError  string
+		expectedOutput string
+	}{
+		{"GB", 87, "80GB", "", "CRITICAL: used is 87GB (outside 80) | used=87GB;;80;;\n"},
+		{"GB", 87, "90GB", "", "OK: | used=87GB;;90;;\n"},
+		{"MB", 512, "1GiB", "", "OK: | used=512MB;;1073.741824;;\n"},
+		{"", 95000000000, "90G", "", "CRITICAL: used is 95000000000 (outside 90000000000) | used=95000000000;;90000000000;;\n"},
+		{"%", 50, "90GB", "Invalid format of critical threshold used: 90GB", "OK:\n"},
+		{"s", 50, "1k", "Invalid format of critical threshold used: 1k", "OK:\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+		check := New("check_plugin", "v1.0")
+
+		err := check.AddMetric("used", test.value, test.uom, "", test.crit)
+		if err != nil && err.Error() != test.expectedError || err == nil && len(test.expectedError) > 0 {
+			t.Errorf("Got error: %v, expected: '%s'", err, test.expectedError)
+		}
+
+		check.Final()
+		if exitHandler.output.String() != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), test.expectedOutput)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var hostnameLabelRe = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_-]{0,61}[a-zA-Z0-9_])?$`)
+
+/*
+ValidateHostPort checks that host is non-empty and looks like a valid IP
+address or hostname, and that port is in range 1-65535. On failure the check
+exits with UNKNOWN status and the reason is returned.
+
+	if err := check.ParseArgs(&opts); err != nil {
+		check.ExitCritical("Error parsing arguments: %s", err)
+	}
+	check.ValidateHostPort(opts.Hostname, opts.Port)
+
+*/
+func (p *Plugin) ValidateHostPort(host string, port int) error {
+	var err error
+
+	switch {
+	case len(host) == 0:
+		err = fmt.Errorf("Host is empty")
+	case !validHost(host):
+		err = fmt.Errorf("Invalid host %s", host)
+	case port < 1 || port > 65535:
+		err = fmt.Errorf("Invalid port %d (expected 1-65535)", port)
+	}
+
+	if err != nil {
+		p.ExitUnknown("%s", err)
+	}
+	return err
+}
+
+func validHost(host string) bool {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return net.ParseIP(host[1:len(host)-1]) != nil
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if !hostnameLabelRe.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ValidateURL parses raw URL and checks it has both scheme and host. On
+failure the check exits with UNKNOWN status and the reason is returned.
+
+	u, err := check.ValidateURL(opts.URL)
+	if err != nil {
+		return
+	}
+	resp, err := http.Get(u.String())
+
+*/
+func (p *Plugin) ValidateURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("Invalid URL %s: %s", raw, err)
+	case len(u.Scheme) == 0:
+		err = fmt.Errorf("Invalid URL %s: missing scheme", raw)
+	case len(u.Host) == 0:
+		err = fmt.Errorf("Invalid URL %s: missing host", raw)
+	}
+
+	if err != nil {
+		p.ExitUnknown("%s", err)
+		return nil, err
+	}
+	return u, nil
+}
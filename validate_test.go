@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"testing"
+)
+
+type ValidateHostPortTest struct {
+	host             string
+	port             int
+	err              string
+	expectedExitCode Status
+	expectedOutput   string
+}
+
+func TestValidateHostPort(t *testing.T) {
+	tests := []ValidateHostPortTest{
+		{"localhost", 80, "", OK, ""},
+		{"example.com.", 65535, "", OK, ""},
+		{"192.168.0.1", 1, "", OK, ""},
+		{"::1", 5666, "", OK, ""},
+		{"[fe80::1]", 5666, "", OK, ""},
+		{"", 80, "Host is empty", UNKNOWN, "UNKNOWN: Host is empty\n"},
+		{"bad host", 80, "Invalid host bad host", UNKNOWN, "UNKNOWN: Invalid host bad host\n"},
+		{"-bad.example.com", 80, "Invalid host -bad.example.com", UNKNOWN, "UNKNOWN: Invalid host -bad.example.com\n"},
+		{"example..com", 80, "Invalid host example..com", UNKNOWN, "UNKNOWN: Invalid host example..com\n"},
+		{"localhost", 0, "Invalid port 0 (expected 1-65535)", UNKNOWN, "UNKNOWN: Invalid port 0 (expected 1-65535)\n"},
+		{"localhost", 65536, "Invalid port 65536 (expected 1-65535)", UNKNOWN, "UNKNOWN: Invalid port 65536 (expected 1-65535)\n"},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		check := New("check_plugin", "v1.0")
+		err := check.ValidateHostPort(test.host, test.port)
+
+		if test.err == "" && err != nil {
+			t.Errorf("Got error: '%s', expected none", err)
+		}
+		if test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+		}
+
+		gotOutput := exitHandler.output.String()
+		if gotOutput != test.expectedOutput {
+			t.Errorf("Got output: '%s', expected: '%s'", gotOutput, test.expectedOutput)
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}
+
+type ValidateURLTest struct {
+	raw              string
+	expectedHost     string
+	err              string
+	expectedExitCode Status
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []ValidateURLTest{
+		{"https://example.com:8443/health?full=1", "example.com:8443", "", OK},
+		{"http://[::1]/", "[::1]", "", OK},
+		{"example.com/health", "", "Invalid URL example.com/health: missing scheme", UNKNOWN},
+		{"http:///health", "", "Invalid URL http:///health: missing host", UNKNOWN},
+		{"file:/etc/passwd", "", "Invalid URL file:/etc/passwd: missing host", UNKNOWN},
+	}
+
+	for _, test := range tests {
+		exitHandler := initExitHandler()
+
+		check := New("check_plugin", "v1.0")
+		u, err := check.ValidateURL(test.raw)
+
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("Got error: '%s', expected none", err)
+			} else if u.Host != test.expectedHost {
+				t.Errorf("Got host: '%s', expected: '%s'", u.Host, test.expectedHost)
+			}
+		} else {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("Got error: '%v', expected: '%s'", err, test.err)
+			}
+			if u != nil {
+				t.Errorf("Got URL: '%s', expected none", u)
+			}
+			if exitHandler.output.String() != "UNKNOWN: "+test.err+"\n" {
+				t.Errorf("Got output: '%s', expected: '%s'", exitHandler.output.String(), "UNKNOWN: "+test.err+"\n")
+			}
+		}
+
+		if exitHandler.code != test.expectedExitCode {
+			t.Errorf("Got code: %d, expected: %d", exitHandler.code, test.expectedExitCode)
+		}
+	}
+}